@@ -19,4 +19,15 @@ const (
 
 	// Unix socket
 	SOCKET_FILE = "/tmp/conf" // "/dev/conf"
+
+	// Database
+	DB_FILE = "/var/lib/piconf/piconf.db"
+)
+
+// Text of the errors of piconfd, which are sent as text to the clients; the
+// client package finds the kind of error by them.
+const (
+	ERR_SAME_CONFIG    = " has program: "     // user + ERR_SAME_CONFIG + program
+	ERR_UNKNOWN_CONFIG = " has not program: " // user + ERR_UNKNOWN_CONFIG + program
+	ERR_UNKNOWN_KEY    = " has not key: "     // "program " + program + ERR_UNKNOWN_KEY + key
 )
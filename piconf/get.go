@@ -0,0 +1,202 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package piconf
+
+import (
+	"context"
+	"fmt"
+)
+
+// The typed accessors return the value of the key, which is given as the names
+// of the sections and the key separated by dots, like "log.level". It is an
+// error if the value is of other type.
+
+// typeError returns the error for a value of key which is not of the type
+// wanted.
+func typeError(key string, v Valuer, want string) error {
+	return fmt.Errorf("key %s is not %s: %T", key, want, v)
+}
+
+// GetBool returns the bool value of key.
+func (c *Client) GetBool(ctx context.Context, uid int, cmdPath, key string) (bool, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return false, err
+	}
+	if v, ok := v.(*Bool); ok {
+		return v.Value, nil
+	}
+	return false, typeError(key, v, "Bool")
+}
+
+// GetInt returns the int value of key.
+func (c *Client) GetInt(ctx context.Context, uid int, cmdPath, key string) (int, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return 0, err
+	}
+	if v, ok := v.(*Int); ok {
+		return v.Value, nil
+	}
+	return 0, typeError(key, v, "Int")
+}
+
+// GetInt64 returns the int64 value of key.
+func (c *Client) GetInt64(ctx context.Context, uid int, cmdPath, key string) (int64, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return 0, err
+	}
+	if v, ok := v.(*Int64); ok {
+		return v.Value, nil
+	}
+	return 0, typeError(key, v, "Int64")
+}
+
+// GetUint returns the uint value of key.
+func (c *Client) GetUint(ctx context.Context, uid int, cmdPath, key string) (uint, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return 0, err
+	}
+	if v, ok := v.(*Uint); ok {
+		return v.Value, nil
+	}
+	return 0, typeError(key, v, "Uint")
+}
+
+// GetUint64 returns the uint64 value of key.
+func (c *Client) GetUint64(ctx context.Context, uid int, cmdPath, key string) (uint64, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return 0, err
+	}
+	if v, ok := v.(*Uint64); ok {
+		return v.Value, nil
+	}
+	return 0, typeError(key, v, "Uint64")
+}
+
+// GetFloat64 returns the float64 value of key.
+func (c *Client) GetFloat64(ctx context.Context, uid int, cmdPath, key string) (float64, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return 0, err
+	}
+	if v, ok := v.(*Float64); ok {
+		return v.Value, nil
+	}
+	return 0, typeError(key, v, "Float64")
+}
+
+// GetComplex128 returns the complex128 value of key.
+func (c *Client) GetComplex128(ctx context.Context, uid int, cmdPath, key string) (complex128, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return 0, err
+	}
+	if v, ok := v.(*Complex128); ok {
+		return v.Value, nil
+	}
+	return 0, typeError(key, v, "Complex128")
+}
+
+// GetString returns the string value of key.
+func (c *Client) GetString(ctx context.Context, uid int, cmdPath, key string) (string, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return "", err
+	}
+	if v, ok := v.(*String); ok {
+		return v.Value, nil
+	}
+	return "", typeError(key, v, "String")
+}
+
+// GetRawBytes returns the []byte value of key.
+func (c *Client) GetRawBytes(ctx context.Context, uid int, cmdPath, key string) ([]byte, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := v.(*RawBytes); ok {
+		return v.Value, nil
+	}
+	return nil, typeError(key, v, "RawBytes")
+}
+
+// GetIntSlice returns the []int value of key.
+func (c *Client) GetIntSlice(ctx context.Context, uid int, cmdPath, key string) ([]int, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := v.(*IntSlice); ok {
+		return v.Value, nil
+	}
+	return nil, typeError(key, v, "IntSlice")
+}
+
+// GetInt64Slice returns the []int64 value of key.
+func (c *Client) GetInt64Slice(ctx context.Context, uid int, cmdPath, key string) ([]int64, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := v.(*Int64Slice); ok {
+		return v.Value, nil
+	}
+	return nil, typeError(key, v, "Int64Slice")
+}
+
+// GetUintSlice returns the []uint value of key.
+func (c *Client) GetUintSlice(ctx context.Context, uid int, cmdPath, key string) ([]uint, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := v.(*UintSlice); ok {
+		return v.Value, nil
+	}
+	return nil, typeError(key, v, "UintSlice")
+}
+
+// GetUint64Slice returns the []uint64 value of key.
+func (c *Client) GetUint64Slice(ctx context.Context, uid int, cmdPath, key string) ([]uint64, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := v.(*Uint64Slice); ok {
+		return v.Value, nil
+	}
+	return nil, typeError(key, v, "Uint64Slice")
+}
+
+// GetFloat64Slice returns the []float64 value of key.
+func (c *Client) GetFloat64Slice(ctx context.Context, uid int, cmdPath, key string) ([]float64, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := v.(*Float64Slice); ok {
+		return v.Value, nil
+	}
+	return nil, typeError(key, v, "Float64Slice")
+}
+
+// GetStringSlice returns the []string value of key.
+func (c *Client) GetStringSlice(ctx context.Context, uid int, cmdPath, key string) ([]string, error) {
+	v, err := c.GetKey(ctx, uid, cmdPath, key)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := v.(*StringSlice); ok {
+		return v.Value, nil
+	}
+	return nil, typeError(key, v, "StringSlice")
+}
@@ -4,44 +4,342 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this
 // file, You can obtain one at http://mozilla.org/MPL/2.0/.
 
-// Package piconf 
-//
+/*
+Package piconf is the client of the configuration server piconfd.
+
+	c, err := piconf.Dial("unix", defconf.SOCKET_FILE)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	port, err := c.GetInt(ctx, os.Getuid(), "/usr/bin/prog", "port")
+
+The Client keeps a pool of connections, which are made again when the server
+is restarted. Every call finishes when the context is done, or after of
+Client.Timeout if the context has not a deadline.
+
+The server authenticates the clients of the Unix socket, so a program can only
+access to the configurations of its user, and read the global configuration
+with user id -1. The clients of TCP can only do Ping.
+*/
 package piconf
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"os/user"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/kless/piconf/defconf"
 )
 
-// SaveData saves data into a file encoded to gob.
-func SaveData(file string, set ExtensionSet) error {
-	f, err := os.Create(file)
+// Time to wait by a call when the context has not a deadline.
+const TIMEOUT = 10 * time.Second
+
+// Number of idle connections kept by a Client.
+const POOL_SIZE = 4
+
+// ErrClosed is returned by the calls after of closing the Client.
+var ErrClosed = errors.New("piconf: client closed")
+
+// == Errors
+
+// SameConfigError is returned by AddConfig when the command configuration for
+// the given user ID has been already added.
+type SameConfigError struct {
+	UID     int
+	CmdPath string
+}
+
+func (e *SameConfigError) Error() string {
+	return userName(e.UID) + defconf.ERR_SAME_CONFIG + e.CmdPath
+}
+
+// UnknownConfigError is returned when the command configuration for the given
+// user ID does not exist.
+type UnknownConfigError struct {
+	UID     int
+	CmdPath string
+}
+
+func (e *UnknownConfigError) Error() string {
+	return userName(e.UID) + defconf.ERR_UNKNOWN_CONFIG + e.CmdPath
+}
+
+// UnknownKeyError is returned when the key does not exist in the command
+// configuration.
+type UnknownKeyError struct {
+	CmdPath string
+	Key     string
+}
+
+func (e *UnknownKeyError) Error() string {
+	return "program " + e.CmdPath + defconf.ERR_UNKNOWN_KEY + e.Key
+}
+
+func userName(uid int) string {
+	uidString := strconv.Itoa(uid)
+
+	user_, err := user.LookupId(uidString)
+	if err == nil {
+		return "user " + user_.Username
+	}
+	return "userid " + uidString
+}
+
+// mapError returns the error sent by the server as the error type of this
+// package, if any; the server sends only the text of the error, which is
+// built with the constants ERR_* of defconf.
+func mapError(err error, uid int, cmdPath, key string) error {
+	e, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+	msg := string(e)
+
+	switch {
+	case strings.HasPrefix(msg, "program "+cmdPath+defconf.ERR_UNKNOWN_KEY):
+		return &UnknownKeyError{cmdPath, msg[len("program "+cmdPath+defconf.ERR_UNKNOWN_KEY):]}
+	case strings.HasSuffix(msg, defconf.ERR_UNKNOWN_CONFIG+cmdPath):
+		return &UnknownConfigError{uid, cmdPath}
+	case strings.HasSuffix(msg, defconf.ERR_SAME_CONFIG+cmdPath):
+		return &SameConfigError{uid, cmdPath}
+	}
+	return err
+}
+
+// ==
+
+// Client is a client of piconfd. It is safe for concurrent use.
+type Client struct {
+	network, addr string
+
+	// Timeout is the time to wait by a call whose context has not a deadline.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*rpc.Client // connections not in use
+	closed bool
+}
+
+// Dial connects to the server at the address on the named network, "unix" or
+// "tcp".
+func Dial(network, addr string) (*Client, error) {
+	c := &Client{network: network, addr: addr, Timeout: TIMEOUT}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	conn, err := c.dial(ctx)
 	if err != nil {
-		return fmt.Errorf("SaveData: %s", err)
+		return nil, err
 	}
-	defer f.Close()
+	c.put(conn)
+	return c, nil
+}
 
-	enc := gob.NewEncoder(f)
+// Close closes the connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if err = enc.Encode(_HEADER); err != nil {
-		goto _error
+	c.closed = true
+	for _, conn := range c.idle {
+		conn.Close()
 	}
+	c.idle = nil
+	return nil
+}
 
-	// Save the extensions at the beginning to can load them.
-	if err = enc.Encode(set); err != nil {
-		goto _error
+func (c *Client) dial(ctx context.Context) (*rpc.Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.addr)
+	if err != nil {
+		return nil, err
 	}
+	return rpc.NewClient(conn), nil
+}
 
-	for i, s := range allSetExts {
-		if set&s != 0 {
-			if err = enc.Encode(allExts[i]); err != nil {
-				goto _error
+// get returns an idle connection, or a new one. It reports whether the
+// connection has been used before.
+func (c *Client) get(ctx context.Context) (conn *rpc.Client, reused bool, err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, false, ErrClosed
+	}
+	if n := len(c.idle); n != 0 {
+		conn = c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, true, nil
+	}
+	c.mu.Unlock()
+
+	conn, err = c.dial(ctx)
+	return conn, false, err
+}
+
+// put returns the connection to the pool, or it closes it if the pool is full.
+func (c *Client) put(conn *rpc.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || len(c.idle) == POOL_SIZE {
+		conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// Methods which do not change the configurations, so they can be called again
+// if the connection is broken after of sending the request.
+var readOnly = map[string]bool{
+	"Ping":         true,
+	"GetConfig":    true,
+	"GetKey":       true,
+	"ListPrograms": true,
+	"ListUsers":    true,
+}
+
+// call calls the method of the service Conf. A connection which is broken,
+// because the server has been restarted, is closed and the call is done again
+// in other connection; but a method which changes the configurations is only
+// called again when its request has not been sent, since the server could
+// have run it.
+func (c *Client) call(ctx context.Context, method string, args, reply interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	for {
+		conn, reused, err := c.get(ctx)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case call := <-conn.Go("Conf."+method, args, reply, make(chan *rpc.Call, 1)).Done:
+			// The connection returns ErrShutdown, without sending the request,
+			// when it has found that the server closed it.
+			if call.Error == rpc.ErrShutdown || call.Error == io.EOF || call.Error == io.ErrUnexpectedEOF {
+				conn.Close()
+				if reused && (call.Error == rpc.ErrShutdown || readOnly[method]) {
+					continue
+				}
+				return call.Error
 			}
+			c.put(conn)
+			return call.Error
+
+		case <-ctx.Done():
+			// The reply could arrive later, so the connection is not used any more.
+			conn.Close()
+			return ctx.Err()
 		}
 	}
+}
 
+// Ping checks if the server is responding.
+func (c *Client) Ping(ctx context.Context) error {
+	var reply string
+	if err := c.call(ctx, "Ping", &struct{}{}, &reply); err != nil {
+		return err
+	}
+	if reply != "pong" {
+		return errors.New("piconf: wrong reply to ping: " + reply)
+	}
 	return nil
-_error:
-	return fmt.Errorf("SaveData: %s", err)
+}
+
+// == Configurations
+
+// argsConf are the arguments of the methods of configurations in piconfd.
+type argsConf struct {
+	UID     int
+	CmdPath string
+	Map     *Map
+}
+
+// argsKey are the arguments of the methods of keys in piconfd.
+type argsKey struct {
+	UID     int
+	CmdPath string
+	Keys    []string
+	Value   Valuer
+}
+
+type replyKey struct {
+	Value Valuer
+}
+
+// AddConfig registers the configuration of a program installed in the given
+// path, for the user id; -1 is used for all users.
+func (c *Client) AddConfig(ctx context.Context, uid int, cmdPath string, m *Map) error {
+	err := c.call(ctx, "AddConfig", argsConf{uid, cmdPath, m}, &struct{}{})
+	return mapError(err, uid, cmdPath, "")
+}
+
+// GetConfig returns the configuration of a program.
+func (c *Client) GetConfig(ctx context.Context, uid int, cmdPath string) (*Map, error) {
+	m := new(Map)
+	if err := c.call(ctx, "GetConfig", argsConf{UID: uid, CmdPath: cmdPath}, m); err != nil {
+		return nil, mapError(err, uid, cmdPath, "")
+	}
+	if m.Value == nil { // an empty map is not encoded
+		m.Value = make(map[string]Valuer)
+	}
+	return m, nil
+}
+
+// DeleteConfig removes the configuration of a program.
+func (c *Client) DeleteConfig(ctx context.Context, uid int, cmdPath string) error {
+	err := c.call(ctx, "DeleteConfig", argsConf{UID: uid, CmdPath: cmdPath}, &struct{}{})
+	return mapError(err, uid, cmdPath, "")
+}
+
+// GetKey returns the value of a key of a program configuration.
+func (c *Client) GetKey(ctx context.Context, uid int, cmdPath, key string) (Valuer, error) {
+	var reply replyKey
+	err := c.call(ctx, "GetKey", argsKey{UID: uid, CmdPath: cmdPath, Keys: strings.Split(key, ".")}, &reply)
+	if err != nil {
+		return nil, mapError(err, uid, cmdPath, key)
+	}
+	return reply.Value, nil
+}
+
+// SetKey sets the value of a key, adding it if it does not exist. The value
+// has to be of the same type than the actual one.
+func (c *Client) SetKey(ctx context.Context, uid int, cmdPath, key string, v Valuer) error {
+	err := c.call(ctx, "SetKey", argsKey{uid, cmdPath, strings.Split(key, "."), v}, &struct{}{})
+	return mapError(err, uid, cmdPath, key)
+}
+
+// DeleteKey removes a key of a program configuration.
+func (c *Client) DeleteKey(ctx context.Context, uid int, cmdPath, key string) error {
+	err := c.call(ctx, "DeleteKey", argsKey{UID: uid, CmdPath: cmdPath, Keys: strings.Split(key, ".")}, &struct{}{})
+	return mapError(err, uid, cmdPath, key)
+}
+
+// ListPrograms returns the paths of the programs configured for the user id.
+func (c *Client) ListPrograms(ctx context.Context, uid int) ([]string, error) {
+	var list []string
+	err := c.call(ctx, "ListPrograms", uid, &list)
+	return list, err
+}
+
+// ListUsers returns the user ids which have some configuration.
+func (c *Client) ListUsers(ctx context.Context) ([]int, error) {
+	var list []int
+	err := c.call(ctx, "ListUsers", struct{}{}, &list)
+	return list, err
 }
@@ -7,9 +7,307 @@
 package piconf
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func Test(t *testing.T) {
+// Types of the arguments in piconfd, which have to be exported to be served.
+type (
+	ArgsConf argsConf
+	ArgsKey  argsKey
+	ReplyKey replyKey
+)
+
+// fakeConf serves the methods of piconfd, with the same errors, to test the
+// client.
+type fakeConf struct {
+	mu sync.Mutex
+	m  map[string]*Map // program path: configuration of the user 1000
+
+	deletes int    // calls to DeleteConfig
+	drop    func() // closes the connections of the clients
+}
+
+func (c *fakeConf) Ping(args *struct{}, reply *string) error {
+	*reply = "pong"
+	return nil
+}
+
+func (c *fakeConf) AddConfig(args ArgsConf, reply *struct{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exist := c.m[args.CmdPath]; exist {
+		return errors.New("userid 1000 has program: " + args.CmdPath)
+	}
+	c.m[args.CmdPath] = args.Map
+	return nil
+}
+
+func (c *fakeConf) GetConfig(args ArgsConf, reply *Map) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, exist := c.m[args.CmdPath]
+	if !exist {
+		return errors.New("userid 1000 has not program: " + args.CmdPath)
+	}
+	*reply = *m
+	return nil
+}
+
+// DeleteConfig breaks the connections after of running, so the client does not
+// get the reply.
+func (c *fakeConf) DeleteConfig(args ArgsConf, reply *struct{}) error {
+	c.mu.Lock()
+	c.deletes++
+	c.mu.Unlock()
+	c.drop()
+	return nil
+}
+
+func (c *fakeConf) GetKey(args ArgsKey, reply *ReplyKey) error {
+	var m Map
+	if err := c.GetConfig(ArgsConf{CmdPath: args.CmdPath}, &m); err != nil {
+		return err
+	}
+	v, found := m.Value[strings.Join(args.Keys, ".")]
+	if !found {
+		return errors.New("program " + args.CmdPath + " has not key: " + strings.Join(args.Keys, "."))
+	}
+	reply.Value = v
+	return nil
+}
+
+func (c *fakeConf) ListUsers(args struct{}, reply *[]int) error {
+	*reply = []int{-1, 1000}
+	return nil
+}
+
+func (c *fakeConf) Sleep(d time.Duration, reply *struct{}) error {
+	time.Sleep(d)
+	return nil
+}
+
+// serve serves the fake server in the unix socket name, until the returned
+// function is called.
+func serve(t *testing.T, name string, conf *fakeConf) (stop func()) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Conf", conf); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("unix", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	conf.drop = func() {
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		conns = nil
+		mu.Unlock()
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go server.ServeConn(conn)
+		}
+	}()
+
+	return func() {
+		ln.Close()
+		conf.drop()
+	}
+}
+
+func TestClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "piconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "conf")
+
+	conf := &fakeConf{m: make(map[string]*Map)}
+	stop := serve(t, socket, conf)
+
+	c, err := Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	if err = c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMap("prog", true).
+		Set("port", &Int{Value: 8080}).
+		Set("log.level", &String{Value: "debug"}).
+		Set("hosts", &StringSlice{Value: []string{"a", "b"}})
+	if err = c.AddConfig(ctx, 1000, "/usr/bin/prog", m); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := c.GetInt(ctx, 1000, "/usr/bin/prog", "port"); err != nil || got != 8080 {
+		t.Errorf("GetInt got %v, %v", got, err)
+	}
+	if got, err := c.GetString(ctx, 1000, "/usr/bin/prog", "log.level"); err != nil || got != "debug" {
+		t.Errorf("GetString got %q, %v", got, err)
+	}
+	if got, err := c.GetStringSlice(ctx, 1000, "/usr/bin/prog", "hosts"); err != nil ||
+		!reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("GetStringSlice got %q, %v", got, err)
+	}
+	if _, err = c.GetBool(ctx, 1000, "/usr/bin/prog", "port"); err == nil ||
+		err.Error() != "key port is not Bool: *piconf.Int" {
+		t.Errorf("GetBool got error %v", err)
+	}
+	if users, err := c.ListUsers(ctx); err != nil || !reflect.DeepEqual(users, []int{-1, 1000}) {
+		t.Errorf("ListUsers got %v, %v", users, err)
+	}
+
+	// Errors
+	err = c.AddConfig(ctx, 1000, "/usr/bin/prog", m)
+	if e, ok := err.(*SameConfigError); !ok || e.UID != 1000 || e.CmdPath != "/usr/bin/prog" {
+		t.Errorf("AddConfig got error %#v", err)
+	}
+	_, err = c.GetConfig(ctx, 1000, "/bin/none")
+	if e, ok := err.(*UnknownConfigError); !ok || e.CmdPath != "/bin/none" {
+		t.Errorf("GetConfig got error %#v", err)
+	}
+	_, err = c.GetInt(ctx, 1000, "/usr/bin/prog", "none")
+	if e, ok := err.(*UnknownKeyError); !ok || e.Key != "none" {
+		t.Errorf("GetInt got error %#v", err)
+	}
+
+	// Timeout
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	err = c.call(tctx, "Sleep", time.Second, &struct{}{})
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("call got error %v, want timeout", err)
+	}
+	c.Timeout = 50 * time.Millisecond
+	if err = c.call(ctx, "Sleep", time.Second, &struct{}{}); err != context.DeadlineExceeded {
+		t.Errorf("call got error %v, want timeout", err)
+	}
+	c.Timeout = TIMEOUT
+
+	// Reconnection after of restarting the server.
+	stop()
+	os.Remove(socket)
+	if err = c.Ping(ctx); err == nil {
+		t.Error("Ping got no error without server")
+	}
+	stop = serve(t, socket, conf)
+	defer stop()
+	if err = c.Ping(ctx); err != nil {
+		t.Errorf("Ping got error after of restarting: %v", err)
+	}
+
+	// A method which changes the configurations is not called again when the
+	// connection is broken after of sending it.
+	if err = c.DeleteConfig(ctx, 1000, "/usr/bin/prog"); err == nil {
+		t.Error("DeleteConfig got no error with the connection broken")
+	}
+	conf.mu.Lock()
+	deletes := conf.deletes
+	conf.mu.Unlock()
+	if deletes != 1 {
+		t.Errorf("DeleteConfig was called %d times, want 1", deletes)
+	}
+
+	c.Close()
+	if err = c.Ping(ctx); err != ErrClosed {
+		t.Errorf("Ping got error %v after of closing", err)
+	}
+}
+
+// TestServerErrors checks that the errors of piconfd are returned as the
+// error types of this package, running the server.
+func TestServerErrors(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building piconfd")
+	}
+	dir, err := ioutil.TempDir("", "piconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "conf")
+	bin := filepath.Join(dir, "piconfd")
+
+	if out, err := exec.Command("go", "build", "-o", bin, "./piconfd").CombinedOutput(); err != nil {
+		t.Fatalf("building piconfd: %s\n%s", err, out)
+	}
+	cmd := exec.Command(bin, "-unix", "-s", socket, "-db", filepath.Join(dir, "piconf.db"))
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	var c *Client
+	for i := 0; ; i++ {
+		if c, err = Dial("unix", socket); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	uid := os.Getuid()
+
+	m := NewMap("prog", true).Set("port", &Int{Value: 8080})
+	if err = c.AddConfig(ctx, uid, "/usr/bin/prog", m); err != nil {
+		t.Fatal(err)
+	}
 
+	err = c.AddConfig(ctx, uid, "/usr/bin/prog", m)
+	if e, ok := err.(*SameConfigError); !ok || e.UID != uid || e.CmdPath != "/usr/bin/prog" {
+		t.Errorf("AddConfig got error %#v", err)
+	}
+	_, err = c.GetConfig(ctx, uid, "/bin/none")
+	if e, ok := err.(*UnknownConfigError); !ok || e.UID != uid || e.CmdPath != "/bin/none" {
+		t.Errorf("GetConfig got error %#v", err)
+	}
+	err = c.DeleteConfig(ctx, uid, "/bin/none")
+	if e, ok := err.(*UnknownConfigError); !ok || e.CmdPath != "/bin/none" {
+		t.Errorf("DeleteConfig got error %#v", err)
+	}
+	_, err = c.GetKey(ctx, uid, "/usr/bin/prog", "none")
+	if e, ok := err.(*UnknownKeyError); !ok || e.CmdPath != "/usr/bin/prog" || e.Key != "none" {
+		t.Errorf("GetKey got error %#v", err)
+	}
+	err = c.DeleteKey(ctx, uid, "/usr/bin/prog", "none")
+	if e, ok := err.(*UnknownKeyError); !ok || e.Key != "none" {
+		t.Errorf("DeleteKey got error %#v", err)
+	}
 }
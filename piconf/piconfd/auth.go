@@ -0,0 +1,202 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/rpc"
+	"os/user"
+	"strconv"
+)
+
+// The clients of the Unix socket are authenticated by the credentials of
+// their process, given by the kernel. A client can only access to the
+// configurations of its user id; the global configuration, with user id -1,
+// can be read by all users but it can only be changed by root or by the
+// members of the admin group. Root can access to all configurations.
+//
+// The clients of TCP can not be authenticated, so they can only do Ping.
+
+// Group id of the administrators of the global configuration; -1 if there is
+// not any.
+var ADMIN_GID = -1
+
+// Cred represents the identity of a client.
+type Cred struct {
+	UID, GID, PID int
+
+	admin bool // whether it can change the global configuration
+}
+
+// newCred returns the identity of the process with the given ids.
+func newCred(uid, gid, pid int) *Cred {
+	cred := &Cred{UID: uid, GID: gid, PID: pid}
+	cred.admin = uid == 0 || (ADMIN_GID != -1 && inGroup(uid, gid, ADMIN_GID))
+	return cred
+}
+
+// inGroup reports whether the user is a member of the group.
+func inGroup(uid, gid, group int) bool {
+	if gid == group {
+		return true
+	}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return false
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, g := range gids {
+		if g == strconv.Itoa(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupGroup returns the id of the named group, which can be given as number.
+func lookupGroup(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// == Errors
+
+var errNoAuth = errors.New("client not authenticated")
+
+// PermissionError is returned when the client has not access to the
+// configurations of the user id.
+type PermissionError struct {
+	uid  int // client
+	want int // configurations
+}
+
+func (e PermissionError) Error() string {
+	return "userid " + strconv.Itoa(e.uid) + " has not access to userid " + strconv.Itoa(e.want)
+}
+
+// canRead checks that the client can read the configurations of uid.
+func (cred *Cred) canRead(uid int) error {
+	if cred == nil {
+		return errNoAuth
+	}
+	if uid == -1 || uid == cred.UID || cred.UID == 0 {
+		return nil
+	}
+	return &PermissionError{cred.UID, uid}
+}
+
+// canWrite checks that the client can change the configurations of uid.
+func (cred *Cred) canWrite(uid int) error {
+	if cred == nil {
+		return errNoAuth
+	}
+	if uid == cred.UID || cred.UID == 0 || (uid == -1 && cred.admin) {
+		return nil
+	}
+	return &PermissionError{cred.UID, uid}
+}
+
+// == Serving
+
+// session serves the methods of Conf to a client, with its identity. It is
+// registered as "Conf" in a server for every connection, since the methods of
+// net/rpc can not know the connection of the call.
+type session struct {
+	c    *Conf
+	cred *Cred // nil if the client is not authenticated
+}
+
+func (s *session) Ping(args *Void, reply *string) error {
+	return s.c.Ping(args, reply)
+}
+
+func (s *session) AddConfig(args ArgsConf, reply *Void) error {
+	return s.c.AddConfig(s.cred, args, reply)
+}
+
+func (s *session) GetConfig(args ArgsConf, reply *Map) error {
+	return s.c.GetConfig(s.cred, args, reply)
+}
+
+func (s *session) DeleteConfig(args ArgsConf, reply *Void) error {
+	return s.c.DeleteConfig(s.cred, args, reply)
+}
+
+func (s *session) GetKey(args ArgsKey, reply *ReplyKey) error {
+	return s.c.GetKey(s.cred, args, reply)
+}
+
+func (s *session) SetKey(args ArgsKey, reply *Void) error {
+	return s.c.SetKey(s.cred, args, reply)
+}
+
+func (s *session) DeleteKey(args ArgsKey, reply *Void) error {
+	return s.c.DeleteKey(s.cred, args, reply)
+}
+
+func (s *session) ListPrograms(uid int, reply *[]string) error {
+	return s.c.ListPrograms(s.cred, uid, reply)
+}
+
+func (s *session) ListUsers(args Void, reply *[]int) error {
+	return s.c.ListUsers(s.cred, args, reply)
+}
+
+// serveConn serves c in the connection, to the client with the identity cred.
+func serveConn(c *Conf, conn net.Conn, cred *Cred) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Conf", &session{c, cred}); err != nil {
+		log.Print(err)
+		conn.Close()
+		return
+	}
+	server.ServeConn(conn)
+}
+
+// serveUnix accepts connections on the Unix socket, and it serves c to every
+// client with the credentials of its process.
+func serveUnix(c *Conf, listen net.Listener) {
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			log.Print("rpc.Serve: accept:", err)
+			return
+		}
+
+		cred, err := peerCred(conn.(*net.UnixConn))
+		if err != nil {
+			log.Print("credentials of client: ", err)
+			conn.Close()
+			continue
+		}
+		go serveConn(c, conn, cred)
+	}
+}
+
+// serveTCP accepts connections on the TCP listener, and it serves c to every
+// client without authentication.
+func serveTCP(c *Conf, listen net.Listener) {
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			log.Print("rpc.Serve: accept:", err)
+			return
+		}
+		go serveConn(c, conn, nil)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCred(t *testing.T) {
+	ADMIN_GID = 500
+	defer func() { ADMIN_GID = -1 }()
+
+	var (
+		user  = newCred(1000, 1000, 1)
+		admin = newCred(1001, 500, 1)
+	)
+	for i, tt := range []struct {
+		cred        *Cred
+		uid         int
+		read, write bool
+	}{
+		{root, 1000, true, true},
+		{root, -1, true, true},
+		{user, 1000, true, true},
+		{user, 1001, false, false},
+		{user, -1, true, false},
+		{admin, -1, true, true},
+		{admin, 1000, false, false},
+		{nil, 1000, false, false},
+		{nil, -1, false, false},
+	} {
+		if got := tt.cred.canRead(tt.uid) == nil; got != tt.read {
+			t.Errorf("#%d canRead(%d) got %v, want %v", i, tt.uid, got, tt.read)
+		}
+		if got := tt.cred.canWrite(tt.uid) == nil; got != tt.write {
+			t.Errorf("#%d canWrite(%d) got %v, want %v", i, tt.uid, got, tt.write)
+		}
+	}
+}
+
+func TestAuthRPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "piconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := openDB(t, filepath.Join(dir, "piconf.db"), SyncAlways)
+	defer closeDB(t, c)
+
+	for _, uid := range []int{-1, 1000, 1001} {
+		m := NewMap("prog", true)
+		m.Set("port", NewInt())
+		if err = c.AddConfig(root, ArgsConf{uid, "/usr/bin/prog", m}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, stop := serve(t, c, newCred(1000, 1000, 1))
+	defer stop()
+	call := func(method string, args, reply interface{}) error {
+		return client.Call("Conf."+method, args, reply)
+	}
+	key := func(uid int) ArgsKey {
+		return ArgsKey{uid, "/usr/bin/prog", []string{"port"}, &Int{Value: 8080}}
+	}
+
+	for _, tt := range []struct {
+		method string
+		args   interface{}
+		reply  interface{}
+		err    string
+	}{
+		{"GetConfig", ArgsConf{UID: 1000, CmdPath: "/usr/bin/prog"}, new(Map), ""},
+		{"GetConfig", ArgsConf{UID: -1, CmdPath: "/usr/bin/prog"}, new(Map), ""},
+		{"GetConfig", ArgsConf{UID: 1001, CmdPath: "/usr/bin/prog"}, new(Map), "userid 1000 has not access to userid 1001"},
+		{"SetKey", key(1000), new(Void), ""},
+		{"SetKey", key(-1), new(Void), "userid 1000 has not access to userid -1"},
+		{"SetKey", key(1001), new(Void), "userid 1000 has not access to userid 1001"},
+		{"GetKey", key(1001), new(ReplyKey), "userid 1000 has not access"},
+		{"DeleteKey", key(1001), new(Void), "userid 1000 has not access"},
+		{"AddConfig", ArgsConf{1001, "/bin/other", NewMap("other", true)}, new(Void), "userid 1000 has not access"},
+		{"DeleteConfig", ArgsConf{UID: -1, CmdPath: "/usr/bin/prog"}, new(Void), "userid 1000 has not access"},
+		{"ListPrograms", 1001, new([]string), "userid 1000 has not access"},
+	} {
+		err := call(tt.method, tt.args, tt.reply)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+			t.Errorf("%s %v: got error %v, want %q", tt.method, tt.args, err, tt.err)
+		}
+	}
+
+	var users []int
+	if err = call("ListUsers", Void{}, &users); err != nil || !reflect.DeepEqual(users, []int{-1, 1000}) {
+		t.Errorf("ListUsers got %v, %v", users, err)
+	}
+	if p := c.m[1000]["/usr/bin/prog"].Get("port").(*Int); p.Get() != 8080 || p.UID != 1000 {
+		t.Errorf("got port %d set by %d", p.Get(), p.UID)
+	}
+
+	// Client not authenticated.
+	client2, stop2 := serve(t, c, nil)
+	defer stop2()
+	var pong string
+	if err = client2.Call("Conf.Ping", &Void{}, &pong); err != nil || pong != "pong" {
+		t.Errorf("Ping got %q, %v", pong, err)
+	}
+	if err = client2.Call("Conf.GetConfig", ArgsConf{UID: -1, CmdPath: "/usr/bin/prog"}, new(Map)); err == nil ||
+		err.Error() != errNoAuth.Error() {
+		t.Errorf("GetConfig got error %v", err)
+	}
+}
+
+func TestPeerCred(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_PEERCRED is only in Linux")
+	}
+	dir, err := ioutil.TempDir("", "piconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("unix", filepath.Join(dir, "conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	done := make(chan *Cred, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			done <- nil
+			return
+		}
+		defer conn.Close()
+		cred, err := peerCred(conn.(*net.UnixConn))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- cred
+	}()
+
+	client, err := rpc.Dial("unix", filepath.Join(dir, "conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	cred := <-done
+	if cred == nil || cred.UID != os.Getuid() || cred.GID != os.Getgid() || cred.PID != os.Getpid() {
+		t.Errorf("got credentials %+v", cred)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCred returns the credentials of the process at the other side of conn,
+// given by the kernel.
+func peerCred(conn *net.UnixConn) (*Cred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *syscall.Ucred
+	var errCred error
+	err = raw.Control(func(fd uintptr) {
+		ucred, errCred = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errCred != nil {
+		return nil, errCred
+	}
+	return newCred(int(ucred.Uid), int(ucred.Gid), int(ucred.Pid)), nil
+}
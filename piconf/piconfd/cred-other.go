@@ -0,0 +1,21 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCred returns the credentials of the process at the other side of conn.
+// SO_PEERCRED is only in Linux.
+func peerCred(conn *net.UnixConn) (*Cred, error) {
+	return nil, errors.New("credentials of the peer not supported in this system")
+}
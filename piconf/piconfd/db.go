@@ -0,0 +1,240 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The database file starts with HEADER and a byte with the version of its
+// format; the rest of the file is encoded with gob.
+//
+// To change the format, increase DB_VERSION and add a decoder for the new
+// version; the decoders of the older versions have to convert their data to
+// the actual one, so the file is written in the new format at the next save.
+
+// Version of the format of the database file.
+const DB_VERSION = 1
+
+// dbData is the content of the database file, in the version 1.
+type dbData struct {
+	Saved   time.Time
+	Configs map[int]map[string]*Map // user id: program path: configuration data
+}
+
+// decoders decode the database file after of the header, for every version of
+// its format.
+var decoders = map[byte]func(*gob.Decoder) (map[int]map[string]*Map, error){
+	1: decodeV1,
+}
+
+func init() {
+	// The types stored in a Valuer, with names independent of the package;
+	// they are used in the database file and in the RPC API.
+	for name, v := range map[string]Valuer{
+		"Bool":         new(Bool),
+		"Int":          new(Int),
+		"Int64":        new(Int64),
+		"Uint":         new(Uint),
+		"Uint64":       new(Uint64),
+		"Float64":      new(Float64),
+		"Complex128":   new(Complex128),
+		"String":       new(String),
+		"RawBytes":     new(RawBytes),
+		"IntSlice":     new(IntSlice),
+		"Int64Slice":   new(Int64Slice),
+		"UintSlice":    new(UintSlice),
+		"Uint64Slice":  new(Uint64Slice),
+		"Float64Slice": new(Float64Slice),
+		"StringSlice":  new(StringSlice),
+		"Map":          new(Map),
+	} {
+		gob.RegisterName(name, v)
+	}
+}
+
+func decodeV1(dec *gob.Decoder) (map[int]map[string]*Map, error) {
+	var d dbData
+	if err := dec.Decode(&d); err != nil {
+		return nil, err
+	}
+	if d.Configs == nil {
+		d.Configs = make(map[int]map[string]*Map)
+	}
+	return d.Configs, nil
+}
+
+// readDB reads a database file from r.
+func readDB(r io.Reader) (map[int]map[string]*Map, error) {
+	var head [len(HEADER) + 1]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil || !bytes.Equal(head[:len(HEADER)], HEADER[:]) {
+		return nil, errors.New("not a database file")
+	}
+
+	version := head[len(HEADER)]
+	decode, found := decoders[version]
+	if !found {
+		return nil, fmt.Errorf("unsupported version %d of the database format", version)
+	}
+	m, err := decode(gob.NewDecoder(r))
+	if err != nil {
+		return nil, fmt.Errorf("database in version %d is not valid: %s", version, err)
+	}
+	return m, nil
+}
+
+// writeDB writes the database m to w, in the actual version of the format.
+func writeDB(w io.Writer, m map[int]map[string]*Map) error {
+	head := append(HEADER[:len(HEADER):len(HEADER)], DB_VERSION)
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(&dbData{time.Now(), m})
+}
+
+// load reads the database from the named file, which is used then to save
+// it, and applies the changes of its log. It is not an error if the file does
+// not exist.
+func (c *Conf) load(name string, policy SyncPolicy) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.file = name
+	f, err := os.Open(name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		m, err := readDB(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		c.m = m
+	}
+
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if c.log, err = openLog(name+".log", policy, c.m); err != nil {
+		return err
+	}
+	c.placeValues()
+	return nil
+}
+
+// save writes the database to its file, if any. It is written to a temporary
+// file which is synced to disk and renamed to the database file, so the file
+// is never left half written; then the log is emptied.
+func (c *Conf) save() error {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.file == "" {
+		return nil
+	}
+	unlock := c.rlockValues()
+	defer unlock()
+
+	dir := filepath.Dir(c.file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(c.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = writeDB(tmp, c.m); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), c.file); err != nil {
+		return err
+	}
+	tmp = nil
+
+	// Commit the rename to disk.
+	if d, err := os.Open(dir); err == nil {
+		err = d.Sync()
+		d.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// The values are locked, so there is not any change out of the snapshot.
+	if c.log != nil {
+		c.log.mu.Lock()
+		defer c.log.mu.Unlock()
+		return c.log.reset()
+	}
+	return nil
+}
+
+// rlocker is implemented by the values, to be read safely.
+type rlocker interface {
+	RLock()
+	RUnlock()
+}
+
+// rlockValues locks for reading all the values of the database, and it
+// returns the function to unlock them.
+func (c *Conf) rlockValues() (unlock func()) {
+	var locked []rlocker
+	for _, progs := range c.m {
+		for _, m := range progs {
+			locked = rlockValue(m, locked)
+		}
+	}
+	return func() {
+		for _, l := range locked {
+			l.RUnlock()
+		}
+	}
+}
+
+// rlockValue locks v for reading, with the values inside a Map, and adds them
+// to locked.
+func rlockValue(v Valuer, locked []rlocker) []rlocker {
+	l, ok := v.(rlocker)
+	if !ok {
+		return locked
+	}
+	l.RLock()
+	locked = append(locked, l)
+
+	if m, ok := v.(*Map); ok {
+		for _, v := range m.Value {
+			locked = rlockValue(v, locked)
+		}
+	}
+	return locked
+}
@@ -0,0 +1,111 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "piconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := NewBool()
+	b.Set(true, 1000)
+	b.Set(false, 0)
+	b.Sethelp("en", "Enable it")
+
+	s := NewStringSlice()
+	s.Set([]string{"a", "b c"}, 1000)
+
+	section := NewMap("log", false)
+	section.Set("level", NewInt())
+
+	m := NewMap("prog", true).Setversion("1.0")
+	m.Set("enable", b)
+	m.Set("names", s)
+	m.Set("log", section)
+
+	c := &Conf{m: map[int]map[string]*Map{1000: {"/usr/bin/prog": m}}}
+	name := filepath.Join(dir, "db", "piconf.db")
+	c.file = name
+	if err = c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c2 := new(Conf)
+	if err = c2.load(name, SyncAlways); err != nil {
+		t.Fatal(err)
+	}
+	m2 := c2.m[1000]["/usr/bin/prog"]
+	if m2 == nil {
+		t.Fatal("configuration not loaded")
+	}
+	if m2.Name != "prog" || !m2.IsMain || m2.Ver != "1.0" {
+		t.Errorf("got map %q, version %q", m2.Name, m2.Ver)
+	}
+	if got, want := m2.String(), m.String(); len(got) != len(want) {
+		t.Errorf("got values %s, want %s", got, want)
+	}
+
+	b2 := m2.Get("enable").(*Bool)
+	if b2.Get() != false || !reflect.DeepEqual(b2.LastValues, []bool{true}) ||
+		!reflect.DeepEqual(b2.LastUIDs, []int{1000}) {
+		t.Errorf("got value %v with history %v %v", b2.Value, b2.LastValues, b2.LastUIDs)
+	}
+	if got := b2.Gethelp("en"); got != "Enable it" {
+		t.Errorf("got help %q", got)
+	}
+	if got := m2.Get("names").(*StringSlice).Get(); !reflect.DeepEqual(got, []string{"a", "b c"}) {
+		t.Errorf("got slice %q", got)
+	}
+	if _, ok := m2.Get("log").(*Map).Get("level").(*Int); !ok {
+		t.Error("section not loaded")
+	}
+
+	// The database file does not exist yet.
+	if err = new(Conf).load(filepath.Join(dir, "none"), SyncAlways); err != nil {
+		t.Errorf("load got error: %s", err)
+	}
+}
+
+func TestDBError(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		err string
+	}{
+		{"", "not a database file"},
+		{"123\x01", "not a database file"},
+		{"707\x09", "unsupported version 9"},
+		{"707\x01xyz", "database in version 1 is not valid"},
+	} {
+		_, err := readDB(strings.NewReader(tt.in))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %q", tt.in, err, tt.err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeDB(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("707\x01")) {
+		t.Errorf("got header %q", buf.Bytes()[:4])
+	}
+	if m, err := readDB(&buf); err != nil || m == nil {
+		t.Errorf("got %v, %v", m, err)
+	}
+}
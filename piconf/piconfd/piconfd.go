@@ -30,7 +30,6 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"net/rpc"
 	"os"
 	"os/user"
 //	"reflect"
@@ -55,14 +54,13 @@ var config struct {
 // Database
 var (
 	HEADER = [3]byte{'7', '0', '7'}
-	db     = Conf{m: make(map[int]map[string]*Map)}
-	once   sync.Once // to write to disk
+	db     = &Conf{m: make(map[int]map[string]*Map)}
 )
 
 // == Errors
 
-// SameConfigError is returned by Conf.Add when the command configuration for
-// the given user ID has been already added.
+// SameConfigError is returned by Conf.AddConfig when the command
+// configuration for the given user ID has been already added.
 type SameConfigError struct {
 	uid     int
 	cmdPath string
@@ -73,13 +71,13 @@ func (e SameConfigError) Error() string {
 
 	user_, err := user.LookupId(uidString)
 	if err == nil {
-		return "user " + user_.Username + " has program: " + e.cmdPath
+		return "user " + user_.Username + defconf.ERR_SAME_CONFIG + e.cmdPath
 	}
-	return "userid " + uidString + " has program: " + e.cmdPath
+	return "userid " + uidString + defconf.ERR_SAME_CONFIG + e.cmdPath
 }
 
-// SameConfigError is returned by Conf.Add when the command configuration for
-// the given user ID does not exist.
+// UnknownConfigError is returned when the command configuration for the given
+// user ID does not exist.
 type UnknownConfigError struct {
 	uid     int
 	cmdPath string
@@ -90,9 +88,20 @@ func (e UnknownConfigError) Error() string {
 
 	user_, err := user.LookupId(uidString)
 	if err == nil {
-		return "user " + user_.Username + " has not program: " + e.cmdPath
+		return "user " + user_.Username + defconf.ERR_UNKNOWN_CONFIG + e.cmdPath
 	}
-	return "userid " + uidString + " has not program: " + e.cmdPath
+	return "userid " + uidString + defconf.ERR_UNKNOWN_CONFIG + e.cmdPath
+}
+
+// UnknownKeyError is returned when the key does not exist in the command
+// configuration.
+type UnknownKeyError struct {
+	cmdPath string
+	key     string
+}
+
+func (e UnknownKeyError) Error() string {
+	return "program " + e.cmdPath + defconf.ERR_UNKNOWN_KEY + e.key
 }
 // ==
 
@@ -104,73 +113,36 @@ type Void struct{}
 type Conf struct {
 	sync.RWMutex
 	m map[int]map[string]*Map // user id: program path: configuration data
-}
 
-type ArgsConf struct {
-	uid     int
-	cmdPath string
-	m       *Map
+	file    string     // database file
+	log     *wal       // log of changes since the last save
+	saveMu  sync.Mutex // for pending
+	pending bool       // whether there is a writing to disk waiting
 }
 
-// Add registers the user's configuration of a program installed in the given path.
-func (c Conf) Add(args ArgsConf, reply *Void) error {
-	c.Lock()
-
-	if _, exist := c.m[args.uid][args.cmdPath]; exist {
-		c.Unlock()
-
-		err := &SameConfigError{args.uid, args.cmdPath}
-		log.Println(err) // TODO: better logging
-		return err
-	}
-	c.m[args.uid] = make(map[string]*Map)
-	c.m[args.uid][args.cmdPath] = args.m
-
-	c.Unlock()
-	return nil
-}
-
-// Get returns the Map for the user id and command path given.
-func (c Conf) Get(args ArgsConf, m *Map) error {
-	c.RLock()
-
-	m, exist := c.m[args.uid][args.cmdPath]
-	if !exist {
-		c.RUnlock()
-
-		err := &UnknownConfigError{args.uid, args.cmdPath}
-		log.Println(err)
-		return err
+// Save writes database in memory to disk after of the given time in
+// TIMEOUT_SAVE, so the changes done in the meantime are written at once.
+func (c *Conf) Save(*Void, *Void) error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	if !c.pending {
+		c.pending = true
+		time.AfterFunc(TIMEOUT_SAVE, func() {
+			c.saveMu.Lock()
+			c.pending = false
+			c.saveMu.Unlock()
+
+			if err := c.save(); err != nil {
+				log.Printf("failed to save data: %v", err)
+			}
+		})
 	}
-
-	c.RUnlock()
-	return nil
-}
-
-// Save writes database in memory to disk after of the given time in TIMEOUT_SAVE.
-func (c Conf) Save(*Void, *Void) error {
-	go func() {
-		//select {
-		//case <-time.After(TIMEOUT_SAVE):
-		//}
-		<-time.After(TIMEOUT_SAVE)
-
-		c.Lock()
-
-		/*if err := ioutil.WriteFile("foo", []byte(s), 0666); err != nil {
-			log.Printf("failed to saved data: %v", err)
-		} else {
-			log.Printf("saved %q", s)
-		}*/
-
-		c.Unlock()
-		once = sync.Once{} // ready for next writing
-	}()
 	return nil
 }
 
 
-func (c Conf) Ping(args *Void, reply *string) error {
+func (c *Conf) Ping(args *Void, reply *string) error {
 	*reply = "pong"
 	return nil
 }
@@ -205,7 +177,7 @@ func cmdPing(*netutil.Command) (string, error) {
 func printUsage() {
 	fmt.Fprintf(os.Stderr, `System configuration server
 
-Usage: piconfd [-v] -tcp [-h -p] -unix [-s] [-wui -http]
+Usage: piconfd [-v] [-db -sync -sync-interval] [-admin] -tcp [-h -p] -unix [-s] [-wui -http]
 
 `)
 	flag.PrintDefaults()
@@ -225,6 +197,12 @@ func main() {
 		fUseUnix = flag.Bool("unix", false, "Unix socket server")
 		fSocket  = flag.String("s", defconf.SOCKET_FILE, "Unix socket file")
 
+		fDB           = flag.String("db", defconf.DB_FILE, "Database file")
+		fSync         = flag.String("sync", "batch", "When the log of changes is synced to disk: always, batch, interval")
+		fSyncInterval = flag.Duration("sync-interval", SYNC_INTERVAL, "Time between syncs of the log, with -sync=interval")
+
+		fAdmin = flag.String("admin", "", "Group which can change the global configuration, besides of root")
+
 		//fUseWUI = flag.Bool("wui", false, "Web interface")
 		//fHTTP   = flag.Uint("http", defconf.HTTP_PORT, "Web port")
 	)
@@ -240,7 +218,21 @@ func main() {
 	start.Verbose, watch.Verbose = *fVerbose, *fVerbose
 	defer start.Exit()
 
-	rpc.Register(db)
+	syncPolicy, err := ParseSyncPolicy(*fSync)
+	if err != nil {
+		log.Fatal(err)
+	}
+	SYNC_INTERVAL = *fSyncInterval
+
+	if err := db.load(*fDB, syncPolicy); err != nil {
+		log.Fatal("database error: ", err)
+	}
+
+	if *fAdmin != "" {
+		if ADMIN_GID, err = lookupGroup(*fAdmin); err != nil {
+			log.Fatal("admin group: ", err)
+		}
+	}
 
 	if *fUseUnix {
 		listen, err := net.Listen("unix", *fSocket)
@@ -248,8 +240,12 @@ func main() {
 			log.Fatal("listen error:", err)
 			return
 		}
+		// All users can connect; they are authenticated by their credentials.
+		if err = os.Chmod(*fSocket, 0666); err != nil {
+			log.Fatal("listen error:", err)
+		}
 
-		go rpc.Accept(listen)
+		go serveUnix(db, listen)
 		//go rpc.ServeConn(listen)
 
 		/*unixServer, err := netutil.NewUnixServer(config.Server, *fSocket, nil)
@@ -268,7 +264,8 @@ func main() {
 			return
 		}
 
-		go rpc.Accept(listen)
+		log.Print("the clients of TCP are not authenticated, so they can only do Ping")
+		go serveTCP(db, listen)
 
 		/*tcpServer, err := netutil.NewTCPServer(config.Server, *fHost, *fPort, nil)
 		if err != nil {
@@ -0,0 +1,340 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The methods of Conf are served through net/rpc, with the service name
+// "Conf", so they are called like "Conf.GetKey"; they get the identity of the
+// client from the session of its connection. The values are transmitted
+// encoded with gob, whose types are registered at init.
+//
+// A key is given by its path from the Map of the program: the names of the
+// sections where it is, and its name.
+
+// ArgsConf are the arguments to handle the configuration of a program.
+type ArgsConf struct {
+	UID     int    // user id; -1 for all users
+	CmdPath string // program path
+	Map     *Map   // configuration, for AddConfig
+}
+
+// ArgsKey are the arguments to handle a key of a program configuration.
+type ArgsKey struct {
+	UID     int
+	CmdPath string
+	Keys    []string // sections and key
+	Value   Valuer   // value, for SetKey
+}
+
+// ReplyKey is the reply of GetKey.
+type ReplyKey struct {
+	Value Valuer
+}
+
+var errNoKey = errors.New("no key given")
+
+// AddConfig registers the user's configuration of a program installed in the
+// given path. Its values are set by the user of the client, without history.
+func (c *Conf) AddConfig(cred *Cred, args ArgsConf, reply *Void) error {
+	if err := cred.canWrite(args.UID); err != nil {
+		return err
+	}
+	if args.Map == nil {
+		return errors.New("no configuration given for program: " + args.CmdPath)
+	}
+	stamp(args.Map, cred.UID, time.Now())
+	c.Lock()
+
+	if _, exist := c.m[args.UID][args.CmdPath]; exist {
+		c.Unlock()
+
+		err := &SameConfigError{args.UID, args.CmdPath}
+		log.Println(err) // TODO: better logging
+		return err
+	}
+	// The configuration is added after of logging it, so it is not seen if
+	// it could not be logged.
+	if c.log != nil {
+		locked := rlockValue(args.Map, nil)
+		err := c.log.append(&record{Op: opPut, UID: args.UID, CmdPath: args.CmdPath, Value: args.Map})
+		for _, l := range locked {
+			l.RUnlock()
+		}
+		if err != nil {
+			c.Unlock()
+			return err
+		}
+	}
+
+	if c.m[args.UID] == nil {
+		c.m[args.UID] = make(map[string]*Map)
+	}
+	c.m[args.UID][args.CmdPath] = args.Map
+
+	args.Map.setPlace(place{conf: c, uid: args.UID, cmdPath: args.CmdPath, self: args.Map})
+	placeMap(args.Map)
+
+	c.Unlock()
+	c.Save(nil, nil)
+	return nil
+}
+
+// GetConfig returns the configuration for the user id and command path given.
+func (c *Conf) GetConfig(cred *Cred, args ArgsConf, reply *Map) error {
+	if err := cred.canRead(args.UID); err != nil {
+		return err
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	m, exist := c.m[args.UID][args.CmdPath]
+	if !exist {
+		err := &UnknownConfigError{args.UID, args.CmdPath}
+		log.Println(err)
+		return err
+	}
+	return copyValue(reply, m)
+}
+
+// DeleteConfig removes the configuration for the user id and command path
+// given.
+func (c *Conf) DeleteConfig(cred *Cred, args ArgsConf, reply *Void) error {
+	if err := cred.canWrite(args.UID); err != nil {
+		return err
+	}
+	c.Lock()
+
+	m, exist := c.m[args.UID][args.CmdPath]
+	if !exist {
+		c.Unlock()
+		return &UnknownConfigError{args.UID, args.CmdPath}
+	}
+	if c.log != nil {
+		if err := c.log.append(&record{Op: opDelete, UID: args.UID, CmdPath: args.CmdPath}); err != nil {
+			c.Unlock()
+			return err
+		}
+	}
+
+	delete(c.m[args.UID], args.CmdPath)
+	if len(c.m[args.UID]) == 0 {
+		delete(c.m, args.UID)
+	}
+	m.setPlace(place{})
+
+	c.Unlock()
+	c.Save(nil, nil)
+	return nil
+}
+
+// GetKey returns the value of a key.
+func (c *Conf) GetKey(cred *Cred, args ArgsKey, reply *ReplyKey) error {
+	if err := cred.canRead(args.UID); err != nil {
+		return err
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	parent, err := c.section(args)
+	if err != nil {
+		return err
+	}
+	v := parent.Get(args.Keys[len(args.Keys)-1])
+	if v == nil {
+		return &UnknownKeyError{args.CmdPath, strings.Join(args.Keys, ".")}
+	}
+	return copyValue(reply, &ReplyKey{v})
+}
+
+// SetKey sets the value of a key, adding it if it does not exist. The value
+// has to be of the same type than the actual one, which is kept in its
+// history; a Map replaces the actual value, and its values have not history.
+// The value is set by the user of the client.
+func (c *Conf) SetKey(cred *Cred, args ArgsKey, reply *Void) error {
+	if err := cred.canWrite(args.UID); err != nil {
+		return err
+	}
+	if args.Value == nil {
+		return errors.New("no value given for key: " + strings.Join(args.Keys, "."))
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	parent, err := c.section(args)
+	if err != nil {
+		return err
+	}
+	key := args.Keys[len(args.Keys)-1]
+
+	if m, ok := args.Value.(*Map); ok {
+		stamp(m, cred.UID, time.Now())
+		return parent.Set(key, m)
+	}
+	if v := parent.Get(key); v != nil {
+		return setValue(v, args.Value, cred.UID)
+	}
+
+	v := reflect.New(reflect.TypeOf(args.Value).Elem()).Interface().(Valuer)
+	if err = setValue(v, args.Value, cred.UID); err != nil {
+		return err
+	}
+	return parent.Set(key, v)
+}
+
+// DeleteKey removes a key.
+func (c *Conf) DeleteKey(cred *Cred, args ArgsKey, reply *Void) error {
+	if err := cred.canWrite(args.UID); err != nil {
+		return err
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	parent, err := c.section(args)
+	if err != nil {
+		return err
+	}
+	found, err := parent.Delete(args.Keys[len(args.Keys)-1])
+	if !found {
+		return &UnknownKeyError{args.CmdPath, strings.Join(args.Keys, ".")}
+	}
+	return err
+}
+
+// ListPrograms returns the paths of the programs configured for the user id,
+// sorted.
+func (c *Conf) ListPrograms(cred *Cred, uid int, reply *[]string) error {
+	if err := cred.canRead(uid); err != nil {
+		return err
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	list := make([]string, 0, len(c.m[uid]))
+	for cmdPath := range c.m[uid] {
+		list = append(list, cmdPath)
+	}
+	sort.Strings(list)
+	*reply = list
+	return nil
+}
+
+// ListUsers returns the user ids which have some configuration, sorted; only
+// the ones which the client can read.
+func (c *Conf) ListUsers(cred *Cred, args Void, reply *[]int) error {
+	if cred == nil {
+		return errNoAuth
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	list := make([]int, 0, len(c.m))
+	for uid := range c.m {
+		if cred.canRead(uid) == nil {
+			list = append(list, uid)
+		}
+	}
+	sort.Ints(list)
+	*reply = list
+	return nil
+}
+
+// section returns the Map where the key given in args is. The caller must hold
+// the lock of c.
+func (c *Conf) section(args ArgsKey) (*Map, error) {
+	if len(args.Keys) == 0 {
+		return nil, errNoKey
+	}
+	m, exist := c.m[args.UID][args.CmdPath]
+	if !exist {
+		return nil, &UnknownConfigError{args.UID, args.CmdPath}
+	}
+
+	for i, k := range args.Keys[:len(args.Keys)-1] {
+		sub, ok := m.Get(k).(*Map)
+		if !ok {
+			return nil, &UnknownKeyError{args.CmdPath, strings.Join(args.Keys[:i+1], ".")}
+		}
+		m = sub
+	}
+	return m, nil
+}
+
+// setValue sets the value of src in dst, which must be of the same type, with
+// its method Set so the value which is replaced is kept in its history.
+func setValue(dst, src Valuer, uid int) error {
+	d, s := reflect.ValueOf(dst), reflect.ValueOf(src)
+	if d.Type() != s.Type() {
+		return fmt.Errorf("wrong type of value: got %s, want %s",
+			s.Type().Elem().Name(), d.Type().Elem().Name())
+	}
+
+	out := d.MethodByName("Set").Call([]reflect.Value{s.Elem().FieldByName("Value"), reflect.ValueOf(uid)})
+	if err, _ := out[0].Interface().(error); err != nil {
+		return err
+	}
+	return nil
+}
+
+// stamp sets the user id and the time of the values in the Map m, sent by a
+// client, and removes their history; so the client can not forge who changed
+// them, nor when.
+func stamp(m *Map, uid int, now time.Time) {
+	if m == nil {
+		return
+	}
+	for _, v := range m.Value {
+		if sub, ok := v.(*Map); ok {
+			stamp(sub, uid, now)
+			continue
+		}
+		rv := reflect.ValueOf(v)
+		if v == nil || rv.IsNil() {
+			continue
+		}
+		rv = rv.Elem()
+		if last := rv.FieldByName("LastValues"); last.IsValid() {
+			last.Set(reflect.MakeSlice(last.Type(), 0, 0))
+		}
+		if f := rv.FieldByName("Common"); f.IsValid() {
+			c := f.Addr().Interface().(*Common)
+			c.LastUIDs, c.LastTimes = make([]int, 0), make([]time.Time, 0)
+			c.UID, c.Time = uid, now
+		}
+	}
+}
+
+// copyValue copies src in dst, which are locked for reading while they are
+// copied, so the reply of a method is not changed while it is sent.
+func copyValue(dst, src interface{}) error {
+	var locked []rlocker
+	if r, ok := src.(*ReplyKey); ok {
+		locked = rlockValue(r.Value, nil)
+	} else {
+		locked = rlockValue(src.(Valuer), nil)
+	}
+
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(src)
+	for _, l := range locked {
+		l.RUnlock()
+	}
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(&b).Decode(dst)
+}
@@ -0,0 +1,191 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Identity of root, to call the methods of Conf directly.
+var root = newCred(0, 0, 0)
+
+// serve serves c through a listener in the process, and returns a client
+// connected to it with the identity cred.
+func serve(t *testing.T, c *Conf, cred *Cred) (*rpc.Client, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(c, conn, cred)
+		}
+	}()
+
+	client, err := rpc.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, func() {
+		client.Close()
+		ln.Close()
+	}
+}
+
+func TestRPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "piconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "piconf.db")
+
+	c := openDB(t, name, SyncAlways)
+	client, stop := serve(t, c, &Cred{UID: 1000, admin: true})
+
+	port := NewInt()
+	port.Set(1, 0)
+	port.Set(80, 0) // history forged by the client
+	port.Sethelp("en", "Port to listen")
+	m := NewMap("prog", true).Setversion("1.0")
+	m.Set("port", port)
+	m.Set("log", NewMap("log", false))
+
+	call := func(method string, args, reply interface{}) error {
+		return client.Call("Conf."+method, args, reply)
+	}
+	wantErr := func(err error, prefix string) {
+		if err == nil || !strings.HasPrefix(err.Error(), prefix) {
+			t.Errorf("got error %v, want %q", err, prefix)
+		}
+	}
+
+	if err = call("AddConfig", ArgsConf{1000, "/usr/bin/prog", m}, new(Void)); err != nil {
+		t.Fatal(err)
+	}
+	if err = call("AddConfig", ArgsConf{1000, "/usr/bin/other", NewMap("other", true)}, new(Void)); err != nil {
+		t.Fatal(err)
+	}
+	if err = call("AddConfig", ArgsConf{-1, "/usr/bin/prog", NewMap("prog", true)}, new(Void)); err != nil {
+		t.Fatal(err)
+	}
+	wantErr(call("AddConfig", ArgsConf{1000, "/usr/bin/prog", m}, new(Void)), "user")
+	if p := c.m[1000]["/usr/bin/prog"].Get("port").(*Int); p.UID != 1000 || p.Time.IsZero() ||
+		len(p.LastValues) != 0 || len(p.LastUIDs) != 0 {
+		t.Errorf("AddConfig got port with uid %d, time %v, history %v", p.UID, p.Time, p.LastValues)
+	}
+
+	var users []int
+	if err = call("ListUsers", Void{}, &users); err != nil || !reflect.DeepEqual(users, []int{-1, 1000}) {
+		t.Errorf("ListUsers got %v, %v", users, err)
+	}
+	var progs []string
+	if err = call("ListPrograms", 1000, &progs); err != nil ||
+		!reflect.DeepEqual(progs, []string{"/usr/bin/other", "/usr/bin/prog"}) {
+		t.Errorf("ListPrograms got %v, %v", progs, err)
+	}
+
+	var got Map
+	if err = call("GetConfig", ArgsConf{UID: 1000, CmdPath: "/usr/bin/prog"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "prog" || got.Ver != "1.0" || got.Value["port"].(*Int).Value != 80 {
+		t.Errorf("GetConfig got %v", got.String())
+	}
+	wantErr(call("GetConfig", ArgsConf{UID: 1000, CmdPath: "/bin/none"}, new(Map)), "user")
+
+	// Keys
+	var reply ReplyKey
+	if err = call("GetKey", ArgsKey{UID: 1000, CmdPath: "/usr/bin/prog", Keys: []string{"port"}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := reply.Value.(*Int); !ok || v.Value != 80 || v.Help["en"] != "Port to listen" {
+		t.Errorf("GetKey got %#v", reply.Value)
+	}
+
+	newPort := &Int{Value: 8080}
+	if err = call("SetKey", ArgsKey{1000, "/usr/bin/prog", []string{"port"}, newPort}, new(Void)); err != nil {
+		t.Fatal(err)
+	}
+	port = c.m[1000]["/usr/bin/prog"].Get("port").(*Int) // the one received
+	if port.Get() != 8080 || !reflect.DeepEqual(port.LastValues, []int{80}) || port.UID != 1000 {
+		t.Errorf("SetKey got %d with history %v, uid %d", port.Get(), port.LastValues, port.UID)
+	}
+	level := &String{Value: "debug"}
+	if err = call("SetKey", ArgsKey{1000, "/usr/bin/prog", []string{"log", "level"}, level}, new(Void)); err != nil {
+		t.Fatal(err)
+	}
+	reply = ReplyKey{}
+	if err = call("GetKey", ArgsKey{UID: 1000, CmdPath: "/usr/bin/prog", Keys: []string{"log", "level"}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := reply.Value.(*String); !ok || v.Value != "debug" || v.UID != 1000 || v.Time.IsZero() {
+		t.Errorf("GetKey got %#v", reply.Value)
+	}
+
+	wantErr(call("SetKey", ArgsKey{1000, "/usr/bin/prog", []string{"port"}, level}, new(Void)),
+		"wrong type of value: got String, want Int")
+
+	forged := &Bool{Value: true}
+	forged.UID, forged.LastValues = 0, []bool{false}
+	section := NewMap("db", false)
+	section.Set("enable", forged)
+	if err = call("SetKey", ArgsKey{1000, "/usr/bin/prog", []string{"db"}, section}, new(Void)); err != nil {
+		t.Fatal(err)
+	}
+	b := c.m[1000]["/usr/bin/prog"].Get("db").(*Map).Get("enable").(*Bool)
+	if b.UID != 1000 || b.Time.IsZero() || len(b.LastValues) != 0 {
+		t.Errorf("SetKey got value with uid %d, time %v, history %v", b.UID, b.Time, b.LastValues)
+	}
+	wantErr(call("SetKey", ArgsKey{1000, "/usr/bin/prog", []string{"port", "x"}, level}, new(Void)),
+		"program /usr/bin/prog has not key: port")
+	wantErr(call("GetKey", ArgsKey{UID: 1000, CmdPath: "/usr/bin/prog", Keys: []string{"none"}}, &reply),
+		"program /usr/bin/prog has not key: none")
+	wantErr(call("GetKey", ArgsKey{UID: 1000, CmdPath: "/usr/bin/prog"}, &reply), "no key given")
+
+	if err = call("DeleteKey", ArgsKey{UID: 1000, CmdPath: "/usr/bin/prog", Keys: []string{"log", "level"}}, new(Void)); err != nil {
+		t.Fatal(err)
+	}
+	wantErr(call("DeleteKey", ArgsKey{UID: 1000, CmdPath: "/usr/bin/prog", Keys: []string{"log", "level"}}, new(Void)),
+		"program /usr/bin/prog has not key: log.level")
+
+	if err = call("DeleteConfig", ArgsConf{UID: -1, CmdPath: "/usr/bin/prog"}, new(Void)); err != nil {
+		t.Fatal(err)
+	}
+	wantErr(call("DeleteConfig", ArgsConf{UID: -1, CmdPath: "/usr/bin/prog"}, new(Void)), "userid -1")
+	if err = call("ListUsers", Void{}, &users); err != nil || !reflect.DeepEqual(users, []int{1000}) {
+		t.Errorf("ListUsers got %v, %v", users, err)
+	}
+
+	// The changes are in the log.
+	stop()
+	closeDB(t, c)
+	c = openDB(t, name, SyncAlways)
+	defer closeDB(t, c)
+
+	if len(c.m) != 1 || len(c.m[1000]) != 2 {
+		t.Fatalf("got database %v", c.m)
+	}
+	m2 := c.m[1000]["/usr/bin/prog"]
+	if p := m2.Get("port").(*Int); p.Get() != 8080 || !reflect.DeepEqual(p.LastValues, []int{80}) {
+		t.Errorf("got port %d with history %v", p.Get(), p.LastValues)
+	}
+	if v := m2.Get("log").(*Map).Get("level"); v != nil {
+		t.Errorf("got key deleted: %v", v)
+	}
+}
@@ -36,12 +36,16 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"log"
 	"strconv"
 	"sync"
 	"time"
 )
 
 // Valuer is the interface to the value stored in the database.
+//
+// The methods which set a value write the change to the log of the database
+// before of returning, and they return the error at writing it, if any.
 type Valuer interface {
 	String() string
 }
@@ -49,8 +53,14 @@ type Valuer interface {
 // == Common fields
 //
 
-// common represents the fields to add to every value type.
-type common struct {
+// mutex is embedded in the values to lock them; it is not exported so it is
+// not written to the database file.
+type mutex struct {
+	sync.RWMutex
+}
+
+// Common represents the fields to add to every value type.
+type Common struct {
 	// Last modifications
 	LastUIDs  []int // user identifiers
 	LastTimes []time.Time
@@ -60,10 +70,11 @@ type common struct {
 	Time time.Time
 
 	Help map[string]string // language: text
-	sync.RWMutex
+	mutex
+	place
 }
 
-func (c common) initCommon() {
+func (c *Common) initCommon() {
 	c.LastUIDs = make([]int, 0)
 	c.LastTimes = make([]time.Time, 0)
 	c.Help = make(map[string]string)
@@ -72,7 +83,7 @@ func (c common) initCommon() {
 // Gethelp returns the text corresponding to the given language; if it is
 // empty or it does not exist then it is used the language by default.
 // It returns an empty string if the language does not exist.
-func (c common) Gethelp(lang string) string {
+func (c *Common) Gethelp(lang string) string {
 	c.RLock()
 	defer c.RUnlock()
 
@@ -88,10 +99,16 @@ func (c common) Gethelp(lang string) string {
 }
 
 // Sethelp adds a help text for the given language.
-func (c common) Sethelp(lang, text string) {
+func (c *Common) Sethelp(lang, text string) error {
 	c.Lock()
+	if c.Help == nil { // read from the database without help texts
+		c.Help = make(map[string]string)
+	}
 	c.Help[lang] = text
+	err := c.logChange()
 	c.Unlock()
+	c.saveConf()
+	return err
 }
 
 // == Basic types
@@ -101,7 +118,7 @@ func (c common) Sethelp(lang, text string) {
 type Bool struct {
 	Value      bool
 	LastValues []bool
-	common
+	Common
 }
 
 // NewBool returns a new bool Value.
@@ -122,7 +139,7 @@ func (v *Bool) Get() bool {
 // Set sets the value, and saves the given user who is updating it; it also
 // saves the time at setting.
 // Before of to do setting, it is backed up the actual values, if any.
-func (v *Bool) Set(value bool, uid int) {
+func (v *Bool) Set(value bool, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -133,9 +150,11 @@ func (v *Bool) Set(value bool, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 // String implements the Valuer interface.
@@ -149,7 +168,7 @@ func (v *Bool) String() string {
 type Int struct {
 	Value      int
 	LastValues []int
-	common
+	Common
 }
 
 func NewInt() *Int {
@@ -165,7 +184,7 @@ func (v *Int) Get() int {
 	return v.Value
 }
 
-func (v *Int) Set(value int, uid int) {
+func (v *Int) Set(value int, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -176,9 +195,11 @@ func (v *Int) Set(value int, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *Int) String() string {
@@ -191,7 +212,7 @@ func (v *Int) String() string {
 type Int64 struct {
 	Value      int64
 	LastValues []int64
-	common
+	Common
 }
 
 func NewInt64() *Int64 {
@@ -207,7 +228,7 @@ func (v *Int64) Get() int64 {
 	return v.Value
 }
 
-func (v *Int64) Set(value int64, uid int) {
+func (v *Int64) Set(value int64, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -218,9 +239,11 @@ func (v *Int64) Set(value int64, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *Int64) String() string {
@@ -233,7 +256,7 @@ func (v *Int64) String() string {
 type Uint struct {
 	Value      uint
 	LastValues []uint
-	common
+	Common
 }
 
 func NewUint() *Uint {
@@ -249,7 +272,7 @@ func (v *Uint) Get() uint {
 	return v.Value
 }
 
-func (v *Uint) Set(value uint, uid int) {
+func (v *Uint) Set(value uint, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -260,9 +283,11 @@ func (v *Uint) Set(value uint, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *Uint) String() string {
@@ -275,7 +300,7 @@ func (v *Uint) String() string {
 type Uint64 struct {
 	Value      uint64
 	LastValues []uint64
-	common
+	Common
 }
 
 func NewUint64() *Uint64 {
@@ -291,7 +316,7 @@ func (v *Uint64) Get() uint64 {
 	return v.Value
 }
 
-func (v *Uint64) Set(value uint64, uid int) {
+func (v *Uint64) Set(value uint64, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -302,9 +327,11 @@ func (v *Uint64) Set(value uint64, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *Uint64) String() string {
@@ -317,7 +344,7 @@ func (v *Uint64) String() string {
 type Float64 struct {
 	Value      float64
 	LastValues []float64
-	common
+	Common
 }
 
 func NewFloat64() *Float64 {
@@ -333,7 +360,7 @@ func (v *Float64) Get() float64 {
 	return v.Value
 }
 
-func (v *Float64) Set(value float64, uid int) {
+func (v *Float64) Set(value float64, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -344,9 +371,11 @@ func (v *Float64) Set(value float64, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *Float64) String() string {
@@ -359,7 +388,7 @@ func (v *Float64) String() string {
 type Complex128 struct {
 	Value      complex128
 	LastValues []complex128
-	common
+	Common
 }
 
 func NewComplex128() *Complex128 {
@@ -375,7 +404,7 @@ func (v *Complex128) Get() complex128 {
 	return v.Value
 }
 
-func (v *Complex128) Set(value complex128, uid int) {
+func (v *Complex128) Set(value complex128, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -386,9 +415,11 @@ func (v *Complex128) Set(value complex128, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *Complex128) String() string {
@@ -401,7 +432,7 @@ func (v *Complex128) String() string {
 type String struct {
 	Value      string
 	LastValues []string
-	common
+	Common
 }
 
 func NewString() *String {
@@ -417,7 +448,7 @@ func (v *String) Get() string {
 	return v.Value
 }
 
-func (v *String) Set(value string, uid int) {
+func (v *String) Set(value string, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -428,9 +459,11 @@ func (v *String) Set(value string, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *String) String() string {
@@ -446,7 +479,7 @@ func (v *String) String() string {
 type RawBytes struct {
 	Value      []byte
 	LastValues [][]byte
-	common
+	Common
 }
 
 func NewRawBytes() *RawBytes {
@@ -463,7 +496,7 @@ func (v *RawBytes) Get() []byte {
 	return v.Value
 }
 
-func (v *RawBytes) Set(value []byte, uid int) {
+func (v *RawBytes) Set(value []byte, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -474,9 +507,11 @@ func (v *RawBytes) Set(value []byte, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *RawBytes) String() string {
@@ -489,7 +524,7 @@ func (v *RawBytes) String() string {
 type IntSlice struct {
 	Value      []int
 	LastValues [][]int
-	common
+	Common
 }
 
 func NewIntSlice() *IntSlice {
@@ -506,7 +541,7 @@ func (v *IntSlice) Get() []int {
 	return v.Value
 }
 
-func (v *IntSlice) Set(value []int, uid int) {
+func (v *IntSlice) Set(value []int, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -517,9 +552,11 @@ func (v *IntSlice) Set(value []int, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *IntSlice) String() string {
@@ -546,7 +583,7 @@ func (v *IntSlice) String() string {
 type Int64Slice struct {
 	Value      []int64
 	LastValues [][]int64
-	common
+	Common
 }
 
 func NewInt64Slice() *Int64Slice {
@@ -563,7 +600,7 @@ func (v *Int64Slice) Get() []int64 {
 	return v.Value
 }
 
-func (v *Int64Slice) Set(value []int64, uid int) {
+func (v *Int64Slice) Set(value []int64, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -574,9 +611,11 @@ func (v *Int64Slice) Set(value []int64, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *Int64Slice) String() string {
@@ -603,7 +642,7 @@ func (v *Int64Slice) String() string {
 type UintSlice struct {
 	Value      []uint
 	LastValues [][]uint
-	common
+	Common
 }
 
 func NewUintSlice() *UintSlice {
@@ -620,7 +659,7 @@ func (v *UintSlice) Get() []uint {
 	return v.Value
 }
 
-func (v *UintSlice) Set(value []uint, uid int) {
+func (v *UintSlice) Set(value []uint, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -631,9 +670,11 @@ func (v *UintSlice) Set(value []uint, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *UintSlice) String() string {
@@ -660,7 +701,7 @@ func (v *UintSlice) String() string {
 type Uint64Slice struct {
 	Value      []uint64
 	LastValues [][]uint64
-	common
+	Common
 }
 
 func NewUint64Slice() *Uint64Slice {
@@ -677,7 +718,7 @@ func (v *Uint64Slice) Get() []uint64 {
 	return v.Value
 }
 
-func (v *Uint64Slice) Set(value []uint64, uid int) {
+func (v *Uint64Slice) Set(value []uint64, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -688,9 +729,11 @@ func (v *Uint64Slice) Set(value []uint64, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *Uint64Slice) String() string {
@@ -717,7 +760,7 @@ func (v *Uint64Slice) String() string {
 type Float64Slice struct {
 	Value      []float64
 	LastValues [][]float64
-	common
+	Common
 }
 
 func NewFloat64Slice() *Float64Slice {
@@ -734,7 +777,7 @@ func (v *Float64Slice) Get() []float64 {
 	return v.Value
 }
 
-func (v *Float64Slice) Set(value []float64, uid int) {
+func (v *Float64Slice) Set(value []float64, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -745,9 +788,11 @@ func (v *Float64Slice) Set(value []float64, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *Float64Slice) String() string {
@@ -774,7 +819,7 @@ func (v *Float64Slice) String() string {
 type StringSlice struct {
 	Value      []string
 	LastValues [][]string
-	common
+	Common
 }
 
 func NewStringSlice() *StringSlice {
@@ -791,7 +836,7 @@ func (v *StringSlice) Get() []string {
 	return v.Value
 }
 
-func (v *StringSlice) Set(value []string, uid int) {
+func (v *StringSlice) Set(value []string, uid int) error {
 	v.Lock()
 
 	if !v.Time.IsZero() {
@@ -802,9 +847,11 @@ func (v *StringSlice) Set(value []string, uid int) {
 	v.UID, v.Value = uid, value
 	v.Time = time.Now()
 
+	err := v.logChange()
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
 }
 
 func (v *StringSlice) String() string {
@@ -839,7 +886,8 @@ type Map struct {
 	Name   string // program name or configuration's section
 	Ver    string // program version
 	Value  map[string]Valuer
-	sync.RWMutex
+	mutex
+	place
 }
 
 // NewMap defines a map with the specified name.
@@ -859,13 +907,51 @@ func (v *Map) Get(key string) Valuer {
 }
 
 // Set sets value in key.
-func (v *Map) Set(key string, val Valuer) {
+func (v *Map) Set(key string, val Valuer) error {
 	v.Lock()
+	if old, found := v.Value[key]; found && old != val {
+		if p, ok := old.(placer); ok {
+			p.setPlace(place{}) // its changes are not logged any more
+		}
+	}
 	v.Value[key] = val
 
+	if p, ok := val.(placer); ok {
+		p.setPlace(place{parent: v, key: key, self: val})
+	}
+	if m, ok := val.(*Map); ok {
+		placeMap(m)
+	}
+	locked := rlockValue(val, nil)
+	err := v.logPut(key, val)
+	for _, l := range locked {
+		l.RUnlock()
+	}
+
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
+	return err
+}
+
+// Delete removes the key, and returns whether it existed.
+func (v *Map) Delete(key string) (found bool, err error) {
+	v.Lock()
+	old, found := v.Value[key]
+	if !found {
+		v.Unlock()
+		return false, nil
+	}
+	if p, ok := old.(placer); ok {
+		p.setPlace(place{})
+	}
+	delete(v.Value, key)
+	err = v.logDelete(key)
+
+	v.Unlock()
+	//<-updated
+	v.saveConf()
+	return true, err
 }
 
 // Setversion sets the program version.
@@ -873,9 +959,12 @@ func (v *Map) Setversion(ver string) *Map {
 	v.Lock()
 	v.Ver = ver
 
+	if err := v.logVersion(); err != nil {
+		log.Print("database log: ", err)
+	}
 	v.Unlock()
 	//<-updated
-	once.Do(func() { db.Save(&Void{}, &Void{}) })
+	v.saveConf()
 	return v
 }
 
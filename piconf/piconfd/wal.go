@@ -0,0 +1,459 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Every change in the database is appended to a log, in the file of the
+// database plus ".log", before of returning; the database file is a snapshot
+// which is written after of TIMEOUT_SAVE, and then the log is emptied. At
+// starting, the records of the log are applied to the snapshot.
+//
+// The log starts with HEADER and the version of the format such as the
+// database file. Every record is the length of its data and its CRC-32 as
+// uint32 in big endian, and the data encoded with gob. A record which is not
+// complete, because of a crash at writing it, is removed at starting.
+
+// SyncPolicy indicates when the log is synced to disk.
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota // after of every record
+	SyncBatch                      // once for the records written at the same time
+	SyncInterval                   // every SYNC_INTERVAL; the last changes can be lost
+)
+
+var syncNames = []string{"always", "batch", "interval"}
+
+func (p SyncPolicy) String() string { return syncNames[p] }
+
+// ParseSyncPolicy returns the policy for the name given.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	for i, n := range syncNames {
+		if n == name {
+			return SyncPolicy(i), nil
+		}
+	}
+	return 0, errors.New("unknown sync policy: " + name)
+}
+
+// Time between syncs of the log with the policy SyncInterval.
+var SYNC_INTERVAL = time.Second
+
+// Operations of the records.
+const (
+	opPut     = iota + 1 // set a value
+	opVersion            // set the version of a Map
+	opDelete             // remove a key, or the Map of a program
+)
+
+// A record is a change in the database. The value is placed by the user id,
+// the program path and the keys from the Map of the program.
+type record struct {
+	Op      int
+	UID     int
+	CmdPath string
+	Keys    []string
+	Value   Valuer
+	Ver     string
+}
+
+const recordHeadLen = 8 // length and CRC-32
+
+// A wal is the log of changes of the database.
+type wal struct {
+	mu      sync.Mutex
+	file    *os.File
+	policy  SyncPolicy
+	written uint64 // number of records written
+	synced  uint64 // number of records synced
+	syncing bool
+	cond    *sync.Cond // signals the end of a sync
+	done    chan struct{}
+}
+
+// openLog opens the named log, and applies its records to the database m.
+// The incomplete records at the end are removed.
+func openLog(name string, policy SyncPolicy, m map[int]map[string]*Map) (*wal, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w := &wal{file: file, policy: policy, done: make(chan struct{})}
+	w.cond = sync.NewCond(&w.mu)
+
+	if err = w.replay(m); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if policy == SyncInterval {
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// replay applies the records of the log to m.
+func (w *wal) replay(m map[int]map[string]*Map) error {
+	data, err := io.ReadAll(w.file)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return w.reset()
+	}
+	head := append(HEADER[:len(HEADER):len(HEADER)], DB_VERSION)
+	if !bytes.HasPrefix(data, head) {
+		return errors.New("not a database log")
+	}
+
+	off := len(head)
+	for off < len(data) {
+		rec, n, err := readRecord(data[off:])
+		if err != nil {
+			log.Printf("database log: %s at offset %d; the rest is removed", err, off)
+			if err = w.file.Truncate(int64(off)); err != nil {
+				return err
+			}
+			if err = w.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		if err = rec.apply(m); err != nil {
+			log.Printf("database log: %s", err)
+		}
+		off += n
+	}
+	return nil
+}
+
+// readRecord reads the record at the beginning of data, and returns its
+// length.
+func readRecord(data []byte) (*record, int, error) {
+	if len(data) < recordHeadLen {
+		return nil, 0, errors.New("incomplete record")
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	sum := binary.BigEndian.Uint32(data[4:])
+	if len(data)-recordHeadLen < size {
+		return nil, 0, errors.New("incomplete record")
+	}
+	payload := data[recordHeadLen : recordHeadLen+size]
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, 0, errors.New("wrong checksum of record")
+	}
+
+	rec := new(record)
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(rec); err != nil {
+		return nil, 0, err
+	}
+	return rec, recordHeadLen + size, nil
+}
+
+// apply applies the change of the record to m.
+func (r *record) apply(m map[int]map[string]*Map) error {
+	if len(r.Keys) == 0 && r.Op == opPut {
+		main, ok := r.Value.(*Map)
+		if !ok {
+			return fmt.Errorf("record of program %s is not a Map", r.CmdPath)
+		}
+		if m[r.UID] == nil {
+			m[r.UID] = make(map[string]*Map)
+		}
+		m[r.UID][r.CmdPath] = main
+		return nil
+	}
+
+	if len(r.Keys) == 0 && r.Op == opDelete {
+		delete(m[r.UID], r.CmdPath)
+		if len(m[r.UID]) == 0 {
+			delete(m, r.UID)
+		}
+		return nil
+	}
+
+	v := m[r.UID][r.CmdPath]
+	if v == nil {
+		return &UnknownConfigError{r.UID, r.CmdPath}
+	}
+	keys := r.Keys
+	if r.Op != opVersion {
+		keys = keys[:len(keys)-1]
+	}
+	for _, k := range keys {
+		if v, _ = v.Value[k].(*Map); v == nil {
+			return fmt.Errorf("record of program %s: key %q is not a Map", r.CmdPath, k)
+		}
+	}
+
+	if v.Value == nil {
+		v.Value = make(map[string]Valuer)
+	}
+	switch r.Op {
+	case opPut:
+		v.Value[r.Keys[len(r.Keys)-1]] = r.Value
+	case opVersion:
+		v.Ver = r.Ver
+	case opDelete:
+		delete(v.Value, r.Keys[len(r.Keys)-1])
+	default:
+		return fmt.Errorf("unknown operation %d in record", r.Op)
+	}
+	return nil
+}
+
+// append writes the record to the log, and syncs it according to the policy.
+func (w *wal) append(r *record) error {
+	var b bytes.Buffer
+	b.Write(make([]byte, recordHeadLen))
+	if err := gob.NewEncoder(&b).Encode(r); err != nil {
+		return err
+	}
+	data := b.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-recordHeadLen))
+	binary.BigEndian.PutUint32(data[4:], crc32.ChecksumIEEE(data[recordHeadLen:]))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Write(data); err != nil {
+		return err
+	}
+	w.written++
+
+	switch w.policy {
+	case SyncAlways:
+		if err := w.file.Sync(); err != nil {
+			return err
+		}
+		w.synced = w.written
+	case SyncBatch:
+		return w.waitSync(w.written)
+	}
+	return nil
+}
+
+// waitSync waits until the record n is synced. The records written while
+// other is syncing are synced together at the next sync.
+func (w *wal) waitSync(n uint64) error {
+	for w.synced < n {
+		if w.syncing {
+			w.cond.Wait()
+			continue
+		}
+
+		w.syncing = true
+		last := w.written
+		w.mu.Unlock()
+		err := w.file.Sync()
+		w.mu.Lock()
+		w.syncing = false
+		w.cond.Broadcast()
+
+		if err != nil {
+			return err
+		}
+		if last > w.synced {
+			w.synced = last
+		}
+	}
+	return nil
+}
+
+// syncLoop syncs the log every SYNC_INTERVAL.
+func (w *wal) syncLoop() {
+	tick := time.NewTicker(SYNC_INTERVAL)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-tick.C:
+			w.mu.Lock()
+			if w.synced < w.written {
+				if err := w.file.Sync(); err != nil {
+					log.Printf("database log: %s", err)
+				} else {
+					w.synced = w.written
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// reset empties the log, after of writing a snapshot of the database. The
+// caller must hold w.mu, but at opening.
+func (w *wal) reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	head := append(HEADER[:len(HEADER):len(HEADER)], DB_VERSION)
+	if _, err := w.file.Write(head); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.synced = w.written
+	return nil
+}
+
+// close syncs and closes the log.
+func (w *wal) close() error {
+	close(w.done)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// == Places
+
+// place is the place of a value in the database, to log its changes. The
+// Map of a program has no parent, and it has the database where it is.
+type place struct {
+	parent  *Map
+	key     string
+	conf    *Conf
+	uid     int
+	cmdPath string
+	self    Valuer // the value placed
+}
+
+// placer is implemented by the values, to be placed.
+type placer interface {
+	setPlace(p place)
+}
+
+// placeMu protects the places of all values, which are set by the Map where
+// they are.
+var placeMu sync.RWMutex
+
+func (p *place) setPlace(pl place) {
+	placeMu.Lock()
+	*p = pl
+	placeMu.Unlock()
+}
+
+// locate returns the database, the user id, the program path and the keys
+// from the Map of the program where the value is; the database is nil if the
+// value is not in one.
+func (p *place) locate() (conf *Conf, uid int, cmdPath string, keys []string) {
+	placeMu.RLock()
+	defer placeMu.RUnlock()
+
+	for p.parent != nil {
+		keys = append([]string{p.key}, keys...)
+		p = &p.parent.place
+	}
+	return p.conf, p.uid, p.cmdPath, keys
+}
+
+// log returns the log of the database where the value is, the user id, the
+// program path and the keys of the value; the log is nil if the value is not
+// in a database with log.
+func (p *place) log() (w *wal, uid int, cmdPath string, keys []string) {
+	conf, uid, cmdPath, keys := p.locate()
+	if conf == nil {
+		return nil, 0, "", nil
+	}
+	return conf.log, uid, cmdPath, keys
+}
+
+// logChange logs the actual content of the value, if it is in the database.
+// The caller must hold the lock of the value.
+func (p *place) logChange() error {
+	w, uid, cmdPath, keys := p.log()
+	if w == nil {
+		return nil
+	}
+	return w.append(&record{Op: opPut, UID: uid, CmdPath: cmdPath, Keys: keys, Value: p.self})
+}
+
+// logPut logs the value val set in key of the Map. The caller must hold the
+// locks of the Map and val.
+func (m *Map) logPut(key string, val Valuer) error {
+	w, uid, cmdPath, keys := m.log()
+	if w == nil {
+		return nil
+	}
+	return w.append(&record{Op: opPut, UID: uid, CmdPath: cmdPath,
+		Keys: append(keys, key), Value: val})
+}
+
+// logDelete logs the removing of key from the Map. The caller must hold its
+// lock.
+func (m *Map) logDelete(key string) error {
+	w, uid, cmdPath, keys := m.log()
+	if w == nil {
+		return nil
+	}
+	return w.append(&record{Op: opDelete, UID: uid, CmdPath: cmdPath,
+		Keys: append(keys, key)})
+}
+
+// logVersion logs the version of the Map. The caller must hold its lock.
+func (m *Map) logVersion() error {
+	w, uid, cmdPath, keys := m.log()
+	if w == nil {
+		return nil
+	}
+	return w.append(&record{Op: opVersion, UID: uid, CmdPath: cmdPath,
+		Keys: keys, Ver: m.Ver})
+}
+
+// saveConf saves, after of a while, the database where the value is, if any.
+// The caller must not hold the lock of the value.
+func (p *place) saveConf() {
+	if conf, _, _, _ := p.locate(); conf != nil {
+		conf.Save(nil, nil)
+	}
+}
+
+// placeValues sets the places of the values of the database.
+func (c *Conf) placeValues() {
+	for uid, progs := range c.m {
+		for cmdPath, main := range progs {
+			main.setPlace(place{conf: c, uid: uid, cmdPath: cmdPath, self: main})
+			placeMap(main)
+		}
+	}
+}
+
+// placeMap sets the places of the values of the Map m. It also makes the map
+// of values, which is not decoded by gob when it is empty.
+func placeMap(m *Map) {
+	if m.Value == nil {
+		m.Value = make(map[string]Valuer)
+	}
+	for key, v := range m.Value {
+		if p, ok := v.(placer); ok {
+			p.setPlace(place{parent: m, key: key, self: v})
+		}
+		if sub, ok := v.(*Map); ok {
+			placeMap(sub)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// openDB loads the database in the named file, with its log.
+func openDB(t *testing.T, name string, policy SyncPolicy) *Conf {
+	c := &Conf{m: make(map[int]map[string]*Map)}
+	if err := c.load(name, policy); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// closeDB closes the log of the database, without saving it.
+func closeDB(t *testing.T, c *Conf) {
+	if err := c.log.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "piconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "piconf.db")
+
+	for _, policy := range []SyncPolicy{SyncAlways, SyncBatch, SyncInterval} {
+		os.Remove(name)
+		os.Remove(name + ".log")
+
+		c := openDB(t, name, policy)
+		m := NewMap("prog", true)
+		if err = c.AddConfig(root, ArgsConf{1000, "/usr/bin/prog", m}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.AddConfig(root, ArgsConf{1000, "/usr/bin/other", NewMap("other", true)}, nil); err != nil {
+			t.Fatal(err)
+		}
+		m.Setversion("1.0")
+
+		port := NewInt()
+		m.Set("port", port)
+		section := NewMap("log", false)
+		m.Set("log", section)
+		level := NewString()
+		section.Set("level", level)
+
+		var wg sync.WaitGroup
+		for i := 1; i <= 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := port.Set(i, 0); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if err = port.Set(8080, 1000); err != nil {
+			t.Fatal(err)
+		}
+		level.Set("debug", 1000)
+		level.Sethelp("en", "Level of logging")
+		closeDB(t, c)
+
+		c = openDB(t, name, policy)
+		m2 := c.m[1000]["/usr/bin/prog"]
+		if m2 == nil || c.m[1000]["/usr/bin/other"] == nil {
+			t.Fatalf("%s: configurations not replayed: %v", policy, c.m)
+		}
+		if m2.Ver != "1.0" {
+			t.Errorf("%s: got version %q", policy, m2.Ver)
+		}
+		port2 := m2.Get("port").(*Int)
+		if port2.Get() != 8080 || len(port2.LastValues) != 10 {
+			t.Errorf("%s: got port %d with history %v", policy, port2.Get(), port2.LastValues)
+		}
+		level2 := m2.Get("log").(*Map).Get("level").(*String)
+		if level2.Get() != "debug" || level2.Gethelp("en") != "Level of logging" {
+			t.Errorf("%s: got level %q, help %q", policy, level2.Get(), level2.Gethelp("en"))
+		}
+
+		// The values replayed are logged again.
+		port2.Set(443, 0)
+		closeDB(t, c)
+		c = openDB(t, name, policy)
+		if got := c.m[1000]["/usr/bin/prog"].Get("port").(*Int).Get(); got != 443 {
+			t.Errorf("%s: got port %d after of replaying twice", policy, got)
+		}
+		closeDB(t, c)
+	}
+}
+
+func TestWALSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "piconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "piconf.db")
+
+	c := openDB(t, name, SyncAlways)
+	m := NewMap("prog", true)
+	c.AddConfig(root, ArgsConf{-1, "/usr/bin/prog", m}, nil)
+	b := NewBool()
+	m.Set("enable", b)
+	b.Set(true, 0)
+
+	if err = c.save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name + ".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(HEADER)+1) {
+		t.Errorf("log got size %d after of the snapshot", info.Size())
+	}
+
+	b.Set(false, 0)
+	closeDB(t, c)
+
+	c = openDB(t, name, SyncAlways)
+	b2 := c.m[-1]["/usr/bin/prog"].Get("enable").(*Bool)
+	if b2.Get() != false || !reflect.DeepEqual(b2.LastValues, []bool{true}) {
+		t.Errorf("got value %v with history %v", b2.Get(), b2.LastValues)
+	}
+	closeDB(t, c)
+}
+
+// The values are logged in the database where they are.
+func TestWALOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "piconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name1 := filepath.Join(dir, "1", "piconf.db")
+	name2 := filepath.Join(dir, "2", "piconf.db")
+
+	c1 := openDB(t, name1, SyncAlways)
+	c2 := openDB(t, name2, SyncAlways)
+	m1, m2 := NewMap("prog", true), NewMap("prog", true)
+	c1.AddConfig(root, ArgsConf{1000, "/usr/bin/prog", m1}, nil)
+	c2.AddConfig(root, ArgsConf{1000, "/usr/bin/prog", m2}, nil)
+	port1, port2 := NewInt(), NewInt()
+	m1.Set("port", port1)
+	m2.Set("port", port2)
+	port1.Set(1, 0)
+	port2.Set(2, 0)
+	closeDB(t, c1)
+	closeDB(t, c2)
+
+	for name, want := range map[string]int{name1: 1, name2: 2} {
+		c := openDB(t, name, SyncAlways)
+		if got := c.m[1000]["/usr/bin/prog"].Get("port").(*Int).Get(); got != want {
+			t.Errorf("%s: got port %d, want %d", name, got, want)
+		}
+		closeDB(t, c)
+	}
+}
+
+// A configuration which can not be logged is not added nor removed.
+func TestWALFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "piconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := openDB(t, filepath.Join(dir, "piconf.db"), SyncAlways)
+	if err = c.AddConfig(root, ArgsConf{1000, "/usr/bin/prog", NewMap("prog", true)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	closeDB(t, c) // the log can not be written
+
+	if err = c.AddConfig(root, ArgsConf{1000, "/usr/bin/other", NewMap("other", true)}, nil); err == nil {
+		t.Error("AddConfig got no error without log")
+	}
+	if _, found := c.m[1000]["/usr/bin/other"]; found {
+		t.Error("AddConfig added the configuration without logging it")
+	}
+	if err = c.DeleteConfig(root, ArgsConf{UID: 1000, CmdPath: "/usr/bin/prog"}, nil); err == nil {
+		t.Error("DeleteConfig got no error without log")
+	}
+	if _, found := c.m[1000]["/usr/bin/prog"]; !found {
+		t.Error("DeleteConfig removed the configuration without logging it")
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "piconfd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "piconf.db")
+
+	c := openDB(t, name, SyncAlways)
+	m := NewMap("prog", true)
+	c.AddConfig(root, ArgsConf{1000, "/usr/bin/prog", m}, nil)
+	s := NewString()
+	m.Set("name", s)
+	s.Set("first", 0)
+	closeDB(t, c)
+
+	logName := name + ".log"
+	good, err := ioutil.ReadFile(logName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		change func([]byte) []byte
+	}{
+		{"incomplete head", func(b []byte) []byte { return append(b, 0, 0, 1) }},
+		{"incomplete data", func(b []byte) []byte { return append(b, 0, 0, 0, 9, 1, 2, 3, 4, 5) }},
+		{"wrong checksum", func(b []byte) []byte {
+			return append(b, 0, 0, 0, 2, 1, 2, 3, 4, 5, 6)
+		}},
+	} {
+		bad := tt.change(append([]byte(nil), good...))
+		if err = ioutil.WriteFile(logName, bad, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		c = openDB(t, name, SyncAlways)
+		if got := c.m[1000]["/usr/bin/prog"].Get("name").(*String).Get(); got != "first" {
+			t.Errorf("%s: got value %q", tt.name, got)
+		}
+		closeDB(t, c)
+
+		if got, _ := ioutil.ReadFile(logName); len(got) != len(good) {
+			t.Errorf("%s: got log of %d bytes, want %d", tt.name, len(got), len(good))
+		}
+	}
+
+	// The last record changed.
+	bad := append([]byte(nil), good...)
+	bad[len(bad)-1] ^= 0xff
+	ioutil.WriteFile(logName, bad, 0600)
+	c = openDB(t, name, SyncAlways)
+	if got := c.m[1000]["/usr/bin/prog"].Get("name").(*String).Get(); got != "" {
+		t.Errorf("got value %q from a wrong record", got)
+	}
+	closeDB(t, c)
+
+	if err = ioutil.WriteFile(logName, []byte("123"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = new(Conf).load(name, SyncAlways); err == nil {
+		t.Error("expected error for a file which is not a log")
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, p := range []SyncPolicy{SyncAlways, SyncBatch, SyncInterval} {
+		if got, err := ParseSyncPolicy(p.String()); err != nil || got != p {
+			t.Errorf("%s: got %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParseSyncPolicy("never"); err == nil {
+		t.Error("expected error")
+	}
+}
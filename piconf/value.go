@@ -0,0 +1,202 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package piconf
+
+import (
+	"encoding/gob"
+	"fmt"
+	"time"
+)
+
+// The values are transmitted encoded with gob, so their types have the same
+// names and fields than the types in piconfd; the fields which are not used
+// by the client, like the last values, are not decoded.
+
+// Valuer is the interface to the values of a configuration.
+type Valuer interface {
+	String() string
+}
+
+// Common represents the information about the actual value.
+type Common struct {
+	UID  int       // user who set it
+	Time time.Time // time at setting
+
+	Help map[string]string // language: text
+}
+
+func init() {
+	for name, v := range map[string]Valuer{
+		"Bool":         new(Bool),
+		"Int":          new(Int),
+		"Int64":        new(Int64),
+		"Uint":         new(Uint),
+		"Uint64":       new(Uint64),
+		"Float64":      new(Float64),
+		"Complex128":   new(Complex128),
+		"String":       new(String),
+		"RawBytes":     new(RawBytes),
+		"IntSlice":     new(IntSlice),
+		"Int64Slice":   new(Int64Slice),
+		"UintSlice":    new(UintSlice),
+		"Uint64Slice":  new(Uint64Slice),
+		"Float64Slice": new(Float64Slice),
+		"StringSlice":  new(StringSlice),
+		"Map":          new(Map),
+	} {
+		gob.RegisterName(name, v)
+	}
+}
+
+// Bool represents a bool value.
+type Bool struct {
+	Value bool
+	Common
+}
+
+func (v *Bool) String() string { return fmt.Sprint(v.Value) }
+
+// Int represents an int value.
+type Int struct {
+	Value int
+	Common
+}
+
+func (v *Int) String() string { return fmt.Sprint(v.Value) }
+
+// Int64 represents an int64 value.
+type Int64 struct {
+	Value int64
+	Common
+}
+
+func (v *Int64) String() string { return fmt.Sprint(v.Value) }
+
+// Uint represents a uint value.
+type Uint struct {
+	Value uint
+	Common
+}
+
+func (v *Uint) String() string { return fmt.Sprint(v.Value) }
+
+// Uint64 represents a uint64 value.
+type Uint64 struct {
+	Value uint64
+	Common
+}
+
+func (v *Uint64) String() string { return fmt.Sprint(v.Value) }
+
+// Float64 represents a float64 value.
+type Float64 struct {
+	Value float64
+	Common
+}
+
+func (v *Float64) String() string { return fmt.Sprint(v.Value) }
+
+// Complex128 represents a complex128 value.
+type Complex128 struct {
+	Value complex128
+	Common
+}
+
+func (v *Complex128) String() string { return fmt.Sprint(v.Value) }
+
+// String represents a string value.
+type String struct {
+	Value string
+	Common
+}
+
+func (v *String) String() string { return fmt.Sprint(v.Value) }
+
+// RawBytes represents a []byte value.
+type RawBytes struct {
+	Value []byte
+	Common
+}
+
+func (v *RawBytes) String() string { return fmt.Sprint(v.Value) }
+
+// IntSlice represents a []int value.
+type IntSlice struct {
+	Value []int
+	Common
+}
+
+func (v *IntSlice) String() string { return fmt.Sprint(v.Value) }
+
+// Int64Slice represents a []int64 value.
+type Int64Slice struct {
+	Value []int64
+	Common
+}
+
+func (v *Int64Slice) String() string { return fmt.Sprint(v.Value) }
+
+// UintSlice represents a []uint value.
+type UintSlice struct {
+	Value []uint
+	Common
+}
+
+func (v *UintSlice) String() string { return fmt.Sprint(v.Value) }
+
+// Uint64Slice represents a []uint64 value.
+type Uint64Slice struct {
+	Value []uint64
+	Common
+}
+
+func (v *Uint64Slice) String() string { return fmt.Sprint(v.Value) }
+
+// Float64Slice represents a []float64 value.
+type Float64Slice struct {
+	Value []float64
+	Common
+}
+
+func (v *Float64Slice) String() string { return fmt.Sprint(v.Value) }
+
+// StringSlice represents a []string value.
+type StringSlice struct {
+	Value []string
+	Common
+}
+
+func (v *StringSlice) String() string { return fmt.Sprint(v.Value) }
+
+// Map represents the configuration of a program, or of a section; the keys in
+// Value are the variable names.
+type Map struct {
+	IsMain bool   // whether the configuration is for a program or section
+	Name   string // program name or configuration's section
+	Ver    string // program version
+	Value  map[string]Valuer
+}
+
+// NewMap defines a map with the specified name.
+func NewMap(name string, isMain bool) *Map {
+	return &Map{
+		IsMain: isMain,
+		Name:   name,
+		Value:  make(map[string]Valuer),
+	}
+}
+
+// Set sets value in key.
+func (v *Map) Set(key string, val Valuer) *Map {
+	if v.Value == nil {
+		v.Value = make(map[string]Valuer)
+	}
+	v.Value[key] = val
+	return v
+}
+
+func (v *Map) String() string { return fmt.Sprint(v.Value) }
@@ -0,0 +1,305 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// The arrays are assigned such as in Bash, and they can span several lines:
+//
+//	MODULES=(a b "c d")
+//	declare -A PORTS=([http]=80 [https]=443)
+//
+// The value of an array, got by the methods for a single value, is the list
+// of its elements separated by a space, such as "${MODULES[*]}" in Bash.
+
+// An arrayKind indicates the type of array of an assignment.
+type arrayKind int
+
+const (
+	noArray arrayKind = iota
+	indexedArray
+	assocArray
+)
+
+// An element is an element of an array.
+type element struct {
+	key   string // subscript of an associative array
+	value string
+	word  word
+}
+
+// declarePrefix returns the length of the command "declare -a" or
+// "declare -A" at the beginning of text, with the blanks after it, and the
+// kind of array declared. It returns zero if there is no such command.
+func declarePrefix(text []byte) (int, arrayKind) {
+	var rest []byte
+	switch {
+	case bytes.HasPrefix(text, []byte("declare")):
+		rest = text[7:]
+	case bytes.HasPrefix(text, []byte("typeset")):
+		rest = text[7:]
+	default:
+		return 0, noArray
+	}
+
+	opt := bytes.TrimLeft(rest, " \t")
+	if len(opt) == len(rest) || len(opt) < 3 || opt[0] != '-' ||
+		(opt[2] != ' ' && opt[2] != '\t') {
+		return 0, noArray
+	}
+	var kind arrayKind
+	switch opt[1] {
+	case 'a':
+		kind = indexedArray
+	case 'A':
+		kind = assocArray
+	default:
+		return 0, noArray
+	}
+	return len(text) - len(bytes.TrimLeft(opt[2:], " \t")), kind
+}
+
+// parseArray sets the elements of the array from src, which is the text after
+// the opening parenthesis. It returns the text after the closing parenthesis.
+func (l *logicalLine) parseArray(src []byte) ([]byte, error) {
+	start := src
+
+	for {
+		// Blanks, newlines and comments between elements.
+		for len(src) != 0 {
+			switch c := src[0]; {
+			case c == ' ', c == '\t', c == '\n', c == '\r':
+				src = src[1:]
+				continue
+			case c == '\\' && len(src) > 1 && src[1] == '\n':
+				src = src[2:]
+				continue
+			case c == '#':
+				if i := bytes.IndexByte(src, '\n'); i != -1 {
+					src = src[i:]
+				} else {
+					src = nil
+				}
+				continue
+			}
+			break
+		}
+		if len(src) == 0 {
+			return nil, incompleteError(start, "unterminated array")
+		}
+		if src[0] == ')' {
+			l.value = l.joinElems()
+			return src[1:], nil
+		}
+
+		var e element
+		if src[0] == '[' {
+			if l.array != assocArray {
+				return nil, newLexError(src, "subscript in indexed array is not supported")
+			}
+			end := bytes.IndexByte(src, ']')
+			if end == -1 || end+1 == len(src) || src[end+1] != '=' {
+				return nil, newLexError(src, "invalid subscript in array")
+			}
+			key, rest, err := lexWord(src[1:end], 0)
+			if err != nil || len(rest) != 0 {
+				return nil, newLexError(src, "invalid subscript in array")
+			}
+			e.key = key.String()
+			src = src[end+2:]
+		} else if l.array == assocArray {
+			return nil, newLexError(src, "missing subscript in associative array")
+		}
+
+		w, rest, err := lexWordIn(src, 0, true)
+		if err != nil {
+			return nil, err
+		}
+		e.word, e.value = w, w.String()
+		l.elems = append(l.elems, e)
+		src = rest
+	}
+}
+
+// joinElems returns the values of the elements of the array separated by a
+// space.
+func (l *logicalLine) joinElems() string {
+	values := make([]string, len(l.elems))
+	for i, e := range l.elems {
+		values[i] = e.value
+	}
+	return strings.Join(values, " ")
+}
+
+// formatArray returns the text of the array assigned in the line.
+func (l *logicalLine) formatArray() []byte {
+	var b bytes.Buffer
+	b.WriteByte('(')
+	for i, e := range l.elems {
+		if i != 0 {
+			b.WriteByte(' ')
+		}
+		if l.array == assocArray {
+			b.WriteByte('[')
+			b.WriteString(Quote(e.key))
+			b.WriteString("]=")
+		}
+		b.WriteString(Quote(e.value))
+	}
+	b.WriteByte(')')
+	return b.Bytes()
+}
+
+// errArray is returned when a single value is set in an array.
+func errArray(key string) error {
+	return errors.New("key " + key + " is an array; use SetStrings or SetStringMap")
+}
+
+// errNoArray is returned when an array is expected in a key.
+func errNoArray(key, kind string) error {
+	return errors.New("key " + key + " is not " + kind)
+}
+
+// line returns the assignment which sets the value of key, or nil.
+func (c *Config) line(key string) *logicalLine {
+	if line := c.external(key); line != nil {
+		return line
+	}
+	return c.find(key)
+}
+
+// Strings returns the elements of the array for a given key. The value of a
+// key which is not an array is returned as a single element.
+func (c *Config) Strings(key string) ([]string, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	line := c.line(key)
+	if line == nil {
+		return nil, &KeyNotFoundError{key}
+	}
+	if line.array == noArray {
+		return []string{c.data[key]}, nil
+	}
+
+	values := make([]string, len(line.elems))
+	for i, e := range line.elems {
+		values[i] = e.value
+	}
+	return values, nil
+}
+
+// StringMap returns the elements of the associative array for a given key.
+func (c *Config) StringMap(key string) (map[string]string, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	line := c.line(key)
+	if line == nil {
+		return nil, &KeyNotFoundError{key}
+	}
+	if line.array != assocArray {
+		return nil, errNoArray(key, "an associative array")
+	}
+
+	m := make(map[string]string, len(line.elems))
+	for _, e := range line.elems {
+		m[e.key] = e.value
+	}
+	return m, nil
+}
+
+// SetStrings sets the elements of the array for key. The change is only done
+// in memory, such as in SetValue.
+func (c *Config) SetStrings(key string, values []string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	line, err := c.findArray(key, indexedArray)
+	if err != nil {
+		return err
+	}
+
+	line.elems = nil
+	for _, v := range values {
+		line.elems = append(line.elems, element{value: v, word: word{{lit: v}}})
+	}
+	line.value = line.joinElems()
+	line.format()
+	c.data[key] = line.value
+	c.update() // the array could span several lines
+	return nil
+}
+
+// SetStringMap sets the elements of the associative array for key. The keys
+// which were already in the array keep their order, and the new ones are
+// added in lexical order. The change is only done in memory, such as in
+// SetValue.
+func (c *Config) SetStringMap(key string, m map[string]string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	line, err := c.findArray(key, assocArray)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	seen := make(map[string]bool, len(m))
+	for _, e := range line.elems {
+		if _, found := m[e.key]; found {
+			keys = append(keys, e.key)
+			seen[e.key] = true
+		}
+	}
+	var added []string
+	for k := range m {
+		if !seen[k] {
+			added = append(added, k)
+		}
+	}
+	sort.Strings(added)
+
+	line.elems = nil
+	for _, k := range append(keys, added...) {
+		line.elems = append(line.elems, element{key: k, value: m[k], word: word{{lit: m[k]}}})
+	}
+	line.value = line.joinElems()
+	line.format()
+	c.data[key] = line.value
+	c.update() // the array could span several lines
+	return nil
+}
+
+// findArray returns the line of the array of the given kind for key.
+func (c *Config) findArray(key string, kind arrayKind) (*logicalLine, error) {
+	if err := c.checkLocal(key); err != nil {
+		return nil, err
+	}
+	line := c.find(key)
+	if line == nil {
+		return nil, &KeyNotFoundError{key}
+	}
+	if line.array != kind {
+		if kind == assocArray {
+			return nil, errNoArray(key, "an associative array")
+		}
+		return nil, errNoArray(key, "an indexed array")
+	}
+	return line, nil
+}
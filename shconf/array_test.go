@@ -0,0 +1,148 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const arrayFile = `MODULES=(a b "c d")
+BASE=80
+declare -A PORTS=(
+	[http]=80  # web
+	[https]=443
+	['my app']="$BASE"1
+)
+EMPTY=()
+export LIST=(one
+  two) # comment
+`
+
+func TestArray(t *testing.T) {
+	cfg, err := (&Parser{Mode: Expand}).Parse(strings.NewReader(arrayFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string][]string{
+		"MODULES": {"a", "b", "c d"},
+		"EMPTY":   {},
+		"LIST":    {"one", "two"},
+		"BASE":    {"80"},
+		"PORTS":   {"80", "443", "801"},
+	} {
+		got, err := cfg.Strings(key)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Strings(%s) got %q, %v, want %q", key, got, err, want)
+		}
+	}
+	if v := cfg.String("MODULES"); v != "a b c d" {
+		t.Errorf("String(MODULES) got %q", v)
+	}
+
+	m, err := cfg.StringMap("PORTS")
+	want := map[string]string{"http": "80", "https": "443", "my app": "801"}
+	if err != nil || !reflect.DeepEqual(m, want) {
+		t.Errorf("StringMap(PORTS) got %v, %v", m, err)
+	}
+	if _, err = cfg.StringMap("MODULES"); err == nil {
+		t.Error("StringMap(MODULES) expected error")
+	}
+	if _, err = cfg.Strings("NONE"); !isNotFound(err) {
+		t.Errorf("Strings(NONE) got error %v", err)
+	}
+
+	var b bytes.Buffer
+	cfg.WriteTo(&b)
+	if b.String() != arrayFile {
+		t.Errorf("WriteTo got\n%s", b.String())
+	}
+
+	// Edition.
+	if err = cfg.SetValue("MODULES", "x"); err == nil {
+		t.Error("SetValue in an array expected error")
+	}
+	if err = cfg.SetStrings("LIST", []string{"x", "y z"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.SetStringMap("PORTS", map[string]string{"ssh": "22", "https": "8443", "ftp": "21"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.SetStrings("PORTS", nil); err == nil {
+		t.Error("SetStrings in an associative array expected error")
+	}
+	if err = cfg.AddKey("NEW", "1", "LIST"); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Reset()
+	cfg.WriteTo(&b)
+	wantFile := `MODULES=(a b "c d")
+BASE=80
+declare -A PORTS=([https]=8443 [ftp]=21 [ssh]=22)
+EMPTY=()
+export LIST=(x "y z") # comment
+NEW=1
+`
+	if b.String() != wantFile {
+		t.Errorf("WriteTo after edition got\n%s", b.String())
+	}
+	if line := cfg.find("NEW"); line.line != 6 {
+		t.Errorf("line of NEW got %d, want 6", line.line)
+	}
+}
+
+func TestArrayError(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		err string
+	}{
+		{"A=(a b", "line 1:4: unterminated array"},
+		{"A=(a (b))", "line 1:6: unexpected character ( in value"},
+		{"A=([1]=a)", "line 1:4: subscript in indexed array is not supported"},
+		{"declare -A A=(a)", "line 1:15: missing subscript in associative array"},
+		{"declare -A A=([a]b)", "line 1:15: invalid subscript in array"},
+		{"declare -a A=b", "line 1:14: missing array after declare"},
+		{"A=(a) b", "line 1:7: unexpected text after value: b"},
+	} {
+		_, err := ParseString(tt.in)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q got error %v, want %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestArrayStruct(t *testing.T) {
+	type config struct {
+		Modules []string       `shconf:"MODULES,array"`
+		Ports   map[string]int `shconf:"PORTS"`
+	}
+
+	cfg, err := ParseString("MODULES=(a 'b c')\ndeclare -A PORTS=([http]=80 [https]=443)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v config
+	if err = Unmarshal(cfg, &v); err != nil {
+		t.Fatal(err)
+	}
+	want := config{[]string{"a", "b c"}, map[string]int{"http": 80, "https": 443}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Unmarshal got %+v, want %+v", v, want)
+	}
+
+	out, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "MODULES=(a \"b c\")\ndeclare -A PORTS=([http]=80 [https]=443)\n"; string(out) != want {
+		t.Errorf("Marshal got %q, want %q", out, want)
+	}
+}
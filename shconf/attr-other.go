@@ -0,0 +1,41 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package shconf
+
+import (
+	"fmt"
+	"os"
+)
+
+// The systems without flock, such as Solaris or Plan 9, or without a file
+// system, such as js/wasm.
+
+func copyOwner(info os.FileInfo, file *os.File) error { return nil }
+
+func syncDir(dir string) error { return nil }
+
+// The files are not locked in these systems.
+func lockFile(name string) (*os.File, error) { return nil, nil }
+
+func unlockFile(file *os.File) error { return nil }
+
+// checkPerm checks that the named file can not be read by the group or by
+// others.
+func checkPerm(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0044 != 0 {
+		return fmt.Errorf("shconf: file %s has secret values and it is readable by others (mode %v)",
+			name, info.Mode().Perm())
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package shconf
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// copyOwner sets the owner and group of the file described by info to file.
+func copyOwner(info os.FileInfo, file *os.File) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if int(st.Uid) == os.Getuid() && int(st.Gid) == os.Getgid() {
+		return nil
+	}
+	return file.Chown(int(st.Uid), int(st.Gid))
+}
+
+// syncDir commits the entries of the directory to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if e := d.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// lockFile locks the named file with an exclusive advisory lock, waiting
+// until it is unlocked by other process. The file is replaced at writing, so
+// it is locked again when the name no longer points to the locked file after
+// of waiting.
+func lockFile(name string) (*os.File, error) {
+	for {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != syscall.EINTR {
+				break
+			}
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		locked, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		current, err := os.Stat(name)
+		if err == nil && os.SameFile(locked, current) {
+			return file, nil
+		}
+		file.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// unlockFile releases the lock got by lockFile.
+func unlockFile(file *os.File) error {
+	return file.Close()
+}
+
+// checkPerm checks that the named file can not be read by the group or by
+// others.
+func checkPerm(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0044 != 0 {
+		return fmt.Errorf("shconf: file %s has secret values and it is readable by others (mode %v)",
+			name, info.Mode().Perm())
+	}
+	return nil
+}
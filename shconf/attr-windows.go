@@ -0,0 +1,24 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build windows
+// +build windows
+
+package shconf
+
+import "os"
+
+func copyOwner(info os.FileInfo, file *os.File) error { return nil }
+
+func syncDir(dir string) error { return nil }
+
+// The files are not locked in Windows.
+func lockFile(name string) (*os.File, error) { return nil, nil }
+
+func unlockFile(file *os.File) error { return nil }
+
+// The permissions are not checked in Windows.
+func checkPerm(name string) error { return nil }
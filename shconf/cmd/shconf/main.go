@@ -0,0 +1,334 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+/*
+Command shconf reads and edits configuration files based in shell variables,
+keeping the quoting, the comments and the rest of lines of the file.
+
+Usage:
+
+	shconf [-f FILE] [-expand] [-source] [-secrets PATTERNS] [-schema FILE]
+	       COMMAND [ARG...]
+
+The commands are:
+
+	get KEY          print the value of KEY
+	set KEY VALUE    set the value of KEY, adding it if it does not exist
+	unset KEY        remove KEY
+	list [-json]     print the keys and values
+	validate         check the syntax of the file, and check it against the
+	                 schema given with -schema, before of the command
+	diff FILE1 FILE2 print the keys added, removed and changed from FILE1
+	                 to FILE2
+
+The file is written such as Config.WriteValue does: a temporary file is
+written and renamed to the file, while it is locked; the keys changed by other
+process since the file was read are merged. The secret values, marked in the
+file or given with -secrets, are redacted by list and diff.
+
+Exit status
+
+	0  success
+	1  other error, or the files are different in diff
+	2  bad usage
+	3  key not found
+	4  syntax error in a file
+	5  the file does not conform to the schema
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kless/config/shconf"
+)
+
+// Exit status.
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitNotFound
+	exitParse
+	exitInvalid
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// A command holds the state to run a command.
+type command struct {
+	fs     *flag.FlagSet
+	stdout io.Writer
+	stderr io.Writer
+	parser shconf.Parser
+	file   string
+}
+
+// run runs the command line args, and returns the exit status.
+func run(args []string, stdout, stderr io.Writer) int {
+	c := &command{
+		fs:     flag.NewFlagSet("shconf", flag.ContinueOnError),
+		stdout: stdout,
+		stderr: stderr,
+	}
+	var (
+		fFile   = c.fs.String("f", "", "configuration file")
+		fExpand = c.fs.Bool("expand", false, "expand the references to keys and environment variables")
+		fSource = c.fs.Bool("source", false, "read the included files and the drop-in directory")
+		fSchema = c.fs.String("schema", "", "schema file, for validate")
+		fSecret = c.fs.String("secrets", "", "patterns of the secret keys, separated by commas")
+	)
+	c.fs.SetOutput(stderr)
+	c.fs.Usage = c.usage
+
+	if err := c.fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	args = c.fs.Args()
+	if len(args) == 0 {
+		c.usage()
+		return exitUsage
+	}
+
+	c.file = *fFile
+	if *fExpand {
+		c.parser.Mode |= shconf.Expand | shconf.ExpandEnv
+	}
+	if *fSource {
+		c.parser.Mode |= shconf.Source | shconf.DropIn
+	}
+	if *fSecret != "" {
+		c.parser.Secrets = strings.Split(*fSecret, ",")
+	}
+
+	cmd, args := args[0], args[1:]
+	nArgs := map[string]int{
+		"get": 1, "set": 2, "unset": 1, "list": -1, "validate": 0, "diff": 2,
+	}
+	n, found := nArgs[cmd]
+	if !found {
+		fmt.Fprintf(stderr, "shconf: unknown command %q\n", cmd)
+		c.usage()
+		return exitUsage
+	}
+	if n != -1 && len(args) != n {
+		fmt.Fprintf(stderr, "shconf: %s needs %d arguments\n", cmd, n)
+		return exitUsage
+	}
+	if cmd != "diff" && c.file == "" {
+		fmt.Fprintf(stderr, "shconf: %s needs a file, given with -f\n", cmd)
+		return exitUsage
+	}
+
+	switch cmd {
+	case "get":
+		return c.get(args[0])
+	case "set":
+		return c.set(args[0], args[1])
+	case "unset":
+		return c.unset(args[0])
+	case "list":
+		return c.list(args)
+	case "validate":
+		return c.validate(*fSchema)
+	case "diff":
+		return c.diff(args[0], args[1])
+	}
+	panic("unreachable")
+}
+
+func (c *command) usage() {
+	fmt.Fprint(c.stderr, `Edit configuration files based in shell variables
+
+Usage: shconf [-f FILE] [-expand] [-source] [-secrets PATTERNS] [-schema FILE]
+              COMMAND [ARG...]
+
+Commands:
+  get KEY
+  set KEY VALUE
+  unset KEY
+  list [-json]
+  validate
+  diff FILE1 FILE2
+
+`)
+	c.fs.PrintDefaults()
+}
+
+// fail prints the error, and returns the exit status for it.
+func (c *command) fail(err error) int {
+	fmt.Fprintln(c.stderr, "shconf:", err)
+
+	switch err.(type) {
+	case *shconf.KeyNotFoundError:
+		return exitNotFound
+	case *shconf.ParseError:
+		return exitParse
+	case shconf.ErrorList:
+		return exitParse
+	}
+	return exitError
+}
+
+// parse parses the named file.
+func (c *command) parse(name string) (*shconf.Config, error) {
+	return c.parser.ParseFile(name)
+}
+
+func (c *command) get(key string) int {
+	cfg, err := c.parse(c.file)
+	if err != nil {
+		return c.fail(err)
+	}
+	if !cfg.Has(key) {
+		return c.fail(&shconf.KeyNotFoundError{Key: key})
+	}
+	fmt.Fprintln(c.stdout, cfg.String(key))
+	return exitOK
+}
+
+func (c *command) set(key, value string) int {
+	cfg, err := c.parse(c.file)
+	if err != nil {
+		return c.fail(err)
+	}
+	cfg.SetConflict(shconf.MergeOnConflict)
+	if cfg.Has(key) {
+		err = cfg.WriteValue(key, value)
+	} else if err = cfg.AddKey(key, value, ""); err == nil {
+		err = cfg.Save()
+	}
+	if err != nil {
+		return c.fail(err)
+	}
+	return exitOK
+}
+
+func (c *command) unset(key string) int {
+	cfg, err := c.parse(c.file)
+	if err != nil {
+		return c.fail(err)
+	}
+	cfg.SetConflict(shconf.MergeOnConflict)
+	if err = cfg.DeleteKey(key); err != nil {
+		return c.fail(err)
+	}
+	if err = cfg.Save(); err != nil {
+		return c.fail(err)
+	}
+	return exitOK
+}
+
+func (c *command) list(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fJSON := fs.Bool("json", false, "print a JSON object")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return exitUsage
+	}
+
+	cfg, err := c.parse(c.file)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	var b bytes.Buffer
+	if !*fJSON {
+		for _, key := range cfg.Keys() {
+			fmt.Fprintf(&b, "%s=%s\n", key, quote(cfg, key))
+		}
+	} else {
+		// The keys are written in the order of the file.
+		b.WriteByte('{')
+		for i, key := range cfg.Keys() {
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			k, _ := json.Marshal(key)
+			v, _ := json.Marshal(cfg.Redacted(key))
+			fmt.Fprintf(&b, "\n  %s: %s", k, v)
+		}
+		b.WriteString("\n}\n")
+	}
+	c.stdout.Write(b.Bytes())
+	return exitOK
+}
+
+// quote returns the value of key quoted for the shell, or redacted if it is
+// secret.
+func quote(cfg *shconf.Config, key string) string {
+	if cfg.IsSecret(key) {
+		return shconf.Redacted
+	}
+	return shconf.Quote(cfg.String(key))
+}
+
+func (c *command) validate(schemaFile string) int {
+	// All the syntax errors are reported.
+	c.parser.Mode |= shconf.Strict
+
+	cfg, err := c.parse(c.file)
+	if err != nil {
+		return c.fail(err)
+	}
+	if schemaFile == "" {
+		return exitOK
+	}
+
+	schema, err := shconf.LoadSchema(schemaFile)
+	if err != nil {
+		fmt.Fprintln(c.stderr, "shconf: schema:", err)
+		return exitError
+	}
+	if err = schema.Validate(cfg); err != nil {
+		for _, e := range err.(shconf.ErrorList) {
+			fmt.Fprintln(c.stderr, e)
+		}
+		return exitInvalid
+	}
+	return exitOK
+}
+
+func (c *command) diff(file1, file2 string) int {
+	cfg1, err := c.parse(file1)
+	if err != nil {
+		return c.fail(err)
+	}
+	cfg2, err := c.parse(file2)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	var b bytes.Buffer
+	for _, key := range cfg1.Keys() {
+		switch {
+		case !cfg2.Has(key):
+			fmt.Fprintf(&b, "-%s=%s\n", key, quote(cfg1, key))
+		case cfg2.String(key) != cfg1.String(key):
+			fmt.Fprintf(&b, "-%s=%s\n", key, quote(cfg1, key))
+			fmt.Fprintf(&b, "+%s=%s\n", key, quote(cfg2, key))
+		}
+	}
+	for _, key := range cfg2.Keys() {
+		if !cfg1.Has(key) {
+			fmt.Fprintf(&b, "+%s=%s\n", key, quote(cfg2, key))
+		}
+	}
+
+	if b.Len() == 0 {
+		return exitOK
+	}
+	c.stdout.Write(b.Bytes())
+	return exitError
+}
@@ -0,0 +1,123 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		name = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	conf := write("conf", "# Server.\nHOST=localhost # name\nPORT=80\n")
+	other := write("other", "HOST=example.com\nNAME='a b'\n")
+	bad := write("bad", "A='x\nB=\nC x\n")
+	schema := write("schema", "HOST='string required'\nPORT='int range=1..1024'\n")
+
+	for _, tt := range []struct {
+		args   []string
+		status int
+		out    string
+	}{
+		{[]string{"-f", conf, "get", "PORT"}, exitOK, "80\n"},
+		{[]string{"-f", conf, "get", "NONE"}, exitNotFound, ""},
+		{[]string{"-f", bad, "get", "A"}, exitParse, ""},
+		{[]string{"-f", conf, "set", "PORT", "8080"}, exitOK, ""},
+		{[]string{"-f", conf, "set", "NAME", "my server"}, exitOK, ""},
+		{[]string{"-f", conf, "list"}, exitOK, "HOST=localhost\nPORT=8080\nNAME=\"my server\"\n"},
+		{[]string{"-f", conf, "list", "--json"}, exitOK,
+			"{\n  \"HOST\": \"localhost\",\n  \"PORT\": \"8080\",\n  \"NAME\": \"my server\"\n}\n"},
+		{[]string{"-f", conf, "validate"}, exitOK, ""},
+		{[]string{"-f", conf, "-schema", schema, "validate"}, exitInvalid, ""},
+		{[]string{"-f", conf, "unset", "NAME"}, exitOK, ""},
+		{[]string{"-f", conf, "unset", "NAME"}, exitNotFound, ""},
+		{[]string{"-f", conf, "-schema", schema, "validate"}, exitInvalid, ""},
+		{[]string{"-f", conf, "set", "PORT", "443"}, exitOK, ""},
+		{[]string{"-f", conf, "-schema", schema, "validate"}, exitOK, ""},
+		{[]string{"-f", bad, "validate"}, exitParse, ""},
+		{[]string{"diff", conf, conf}, exitOK, ""},
+		{[]string{"diff", conf, other}, exitError,
+			"-HOST=localhost\n+HOST=example.com\n-PORT=443\n+NAME=\"a b\"\n"},
+		{[]string{"get", "PORT"}, exitUsage, ""},
+		{[]string{"-f", conf, "get"}, exitUsage, ""},
+		{[]string{"-f", conf, "remove", "PORT"}, exitUsage, ""},
+		{[]string{}, exitUsage, ""},
+	} {
+		var stdout, stderr bytes.Buffer
+		status := run(tt.args, &stdout, &stderr)
+		if status != tt.status {
+			t.Errorf("%q got status %d, want %d; stderr: %s", tt.args, status, tt.status, stderr.String())
+		}
+		if got := stdout.String(); got != tt.out {
+			t.Errorf("%q got output %q, want %q", tt.args, got, tt.out)
+		}
+	}
+
+	// The rest of the file is kept.
+	got, err := ioutil.ReadFile(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# Server.\nHOST=localhost # name\nPORT=443\n"; string(got) != want {
+		t.Errorf("file got %q, want %q", got, want)
+	}
+
+	var stderr bytes.Buffer
+	run([]string{"-f", conf, "-schema", schema, "set", "PORT", "0"}, ioutil.Discard, ioutil.Discard)
+	run([]string{"-f", conf, "-schema", schema, "validate"}, ioutil.Discard, &stderr)
+	if !strings.Contains(stderr.String(), "conf:3: key PORT: 0 out of range 1..1024") {
+		t.Errorf("validate got error output %q", stderr.String())
+	}
+}
+
+func TestRunSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := filepath.Join(dir, "conf")
+	other := filepath.Join(dir, "other")
+	ioutil.WriteFile(conf, []byte("USER=admin\nDB_PASS=secret1\nTOKEN=t1 # @secret\n"), 0600)
+	ioutil.WriteFile(other, []byte("USER=admin\nDB_PASS=secret2\nTOKEN=t2 # @secret\n"), 0600)
+
+	for _, tt := range []struct {
+		args []string
+		out  string
+	}{
+		{[]string{"-f", conf, "-secrets", "*_PASS", "list"},
+			"USER=admin\nDB_PASS=********\nTOKEN=********\n"},
+		{[]string{"-f", conf, "-secrets", "*_PASS", "list", "-json"},
+			"{\n  \"USER\": \"admin\",\n  \"DB_PASS\": \"********\",\n  \"TOKEN\": \"********\"\n}\n"},
+		{[]string{"-secrets", "*_PASS", "diff", conf, other},
+			"-DB_PASS=********\n+DB_PASS=********\n-TOKEN=********\n+TOKEN=********\n"},
+		{[]string{"-f", conf, "get", "TOKEN"}, "t1\n"},
+	} {
+		var stdout bytes.Buffer
+		run(tt.args, &stdout, ioutil.Discard)
+		if got := stdout.String(); got != tt.out {
+			t.Errorf("%q got output %q, want %q", tt.args, got, tt.out)
+		}
+	}
+}
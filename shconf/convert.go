@@ -0,0 +1,746 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A Format is a format of configuration file, to convert to and from.
+//
+// The values are always strings, and the keys are written in the order of the
+// configuration, with the comments above of them. The arrays are written as
+// arrays of strings, and the associative arrays as objects, or inline tables
+// in TOML; INI has not arrays, so their elements are written joined by spaces.
+// In INI, TOML and YAML the comments are written as lines starting with "#".
+// In JSON, which has no comments, they are written as members with the name of
+// the key preceded by "#"; the comment about the file is in the member "#", and
+// the comment after of the last key is in the member "#/".
+type Format int
+
+const (
+	JSON Format = iota
+	INI         // the keys are in sections named as the prefix of the key until "_"
+	TOML        // flat subset: keys with strings and arrays, without tables
+	YAML        // flat subset: keys with scalars and flow arrays, without nested values
+)
+
+var formatNames = [...]string{"JSON", "INI", "TOML", "YAML"}
+
+func (f Format) String() string {
+	if f < 0 || int(f) >= len(formatNames) {
+		return "Format(" + strconv.Itoa(int(f)) + ")"
+	}
+	return formatNames[f]
+}
+
+// A document is a configuration independent of its format.
+type document struct {
+	header  string // comment about the file
+	entries []entry
+	footer  string // comment after of the last key
+}
+
+// An entry is a key with its value and comments.
+type entry struct {
+	key, value string
+	array      arrayKind
+	elems      []element // elements of an array, with value set to them joined
+	comment    string    // comment above the key
+	trailing   string    // comment after the value
+	blank      bool      // whether there is a blank line before
+}
+
+// shell is the format of the configuration itself, used to import the others.
+const shell Format = -1
+
+// A docBuilder builds a document from the lines of a configuration.
+type docBuilder struct {
+	d       document
+	comment []string
+	blank   bool
+}
+
+func (b *docBuilder) addComment(text string) {
+	b.comment = append(b.comment, text)
+}
+
+func (b *docBuilder) addBlank() {
+	// The comment at the beginning, followed by a blank line, is about the file.
+	if len(b.d.entries) == 0 && b.d.header == "" && len(b.comment) != 0 {
+		b.d.header = strings.Join(b.comment, "\n")
+		b.comment = nil
+		return
+	}
+	b.blank = true
+}
+
+// addEntry adds e, with the comment and blank line read before of it.
+func (b *docBuilder) addEntry(e entry) {
+	e.comment = strings.Join(b.comment, "\n")
+	e.blank = b.blank
+	b.d.entries = append(b.d.entries, e)
+	b.comment, b.blank = nil, false
+}
+
+// lineEntry returns the entry for the assignment in line, whose value is
+// value.
+func lineEntry(line *logicalLine, value, trailing string) entry {
+	e := entry{key: line.key, value: value, trailing: trailing, array: line.array}
+	if line.array != noArray {
+		e.elems = line.elems
+	}
+	return e
+}
+
+func (b *docBuilder) document() *document {
+	b.d.footer = strings.Join(b.comment, "\n")
+	return &b.d
+}
+
+// Export writes the configuration to w in the format f.
+func (c *Config) Export(w io.Writer, f Format) error {
+	c.RLock()
+	var b docBuilder
+
+	for _, line := range c.lines {
+		switch line.kind {
+		case blankLine:
+			b.addBlank()
+		case commentLine:
+			b.addComment(line.comment)
+		case assignLine:
+			comment := line.comment
+			if ext := c.external(line.key); ext != nil {
+				// The value is set in other file.
+				line = ext
+			}
+			b.addEntry(lineEntry(line, c.data[line.key], comment))
+		}
+	}
+	for _, line := range c.externals {
+		if c.find(line.key) == nil {
+			b.addEntry(lineEntry(line, line.value, ""))
+		}
+	}
+	c.RUnlock()
+
+	d := b.document()
+	var buf bytes.Buffer
+
+	switch f {
+	case JSON:
+		d.writeJSON(&buf)
+	case INI:
+		d.writeINI(&buf)
+	case TOML, YAML:
+		d.writeFlat(&buf, f)
+	default:
+		return errors.New("shconf: unknown format " + f.String())
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Import creates a new Config from the configuration in the format f read
+// from r. The keys have to be valid names of shell variables.
+func Import(r io.Reader, f Format) (*Config, error) {
+	var d *document
+	var err error
+
+	switch f {
+	case JSON:
+		d, err = readJSON(r)
+	case INI, TOML, YAML:
+		d, err = readFlat(r, f)
+	default:
+		return nil, errors.New("shconf: unknown format " + f.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	d.writeFlat(&b, shell)
+	return Parse(&b)
+}
+
+// writeFlat writes the document as lines "KEY" + separator + value, in the
+// format f, which is INI, TOML, YAML or shell.
+func (d *document) writeFlat(b *bytes.Buffer, f Format) {
+	sep, quote := " = ", dquote
+	switch f {
+	case shell:
+		sep, quote = "=", Quote
+	case INI:
+		quote = iniQuote
+	case YAML:
+		sep = ": "
+	}
+
+	if d.header != "" {
+		writeComment(b, d.header)
+		b.WriteByte('\n')
+	}
+	for i, e := range d.entries {
+		if e.blank && i != 0 {
+			b.WriteByte('\n')
+		}
+		if e.comment != "" {
+			writeComment(b, e.comment)
+		}
+		if f == shell && e.array == assocArray {
+			b.WriteString("declare -A ")
+		}
+		b.WriteString(e.key)
+		b.WriteString(sep)
+		if e.array != noArray && f != INI {
+			writeArray(b, e, f)
+		} else {
+			b.WriteString(quote(e.value))
+		}
+		if e.trailing != "" {
+			b.WriteString(" # ")
+			b.WriteString(e.trailing)
+		}
+		b.WriteByte('\n')
+	}
+	if d.footer != "" {
+		if len(d.entries) != 0 {
+			b.WriteByte('\n')
+		}
+		writeComment(b, d.footer)
+	}
+}
+
+// writeArray writes the elements of the array in e, in the format f.
+func writeArray(b *bytes.Buffer, e entry, f Format) {
+	open, close, sep, keySep := "[", "]", ", ", ""
+	quote := dquote
+	if e.array == assocArray {
+		open, close, keySep = "{", "}", ": "
+	}
+	switch {
+	case f == shell:
+		open, close, sep, quote = "(", ")", " ", Quote
+	case f == TOML && e.array == assocArray:
+		open, close, keySep = "{ ", " }", " = "
+	}
+
+	b.WriteString(open)
+	for i, el := range e.elems {
+		if i != 0 {
+			b.WriteString(sep)
+		}
+		if e.array == assocArray {
+			if f == shell {
+				b.WriteString("[" + Quote(el.key) + "]=")
+			} else {
+				b.WriteString(dquote(el.key) + keySep)
+			}
+		}
+		b.WriteString(quote(el.value))
+	}
+	b.WriteString(close)
+}
+
+// writeINI writes the document in INI format. The keys without section are
+// written first, and then every section in the order of its first key.
+func (d *document) writeINI(b *bytes.Buffer) {
+	var sections []string
+	bySection := make(map[string][]entry)
+
+	for _, e := range d.entries {
+		section, name := iniSection(e.key)
+		if _, found := bySection[section]; !found && section != "" {
+			sections = append(sections, section)
+		}
+		e.key = name
+		// INI has not comments after of the value.
+		if e.trailing != "" {
+			if e.comment != "" {
+				e.comment += "\n"
+			}
+			e.comment += e.trailing
+			e.trailing = ""
+		}
+		bySection[section] = append(bySection[section], e)
+	}
+
+	(&document{header: d.header, entries: bySection[""]}).writeFlat(b, INI)
+	for _, section := range sections {
+		if b.Len() != 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString("[" + section + "]\n")
+		(&document{entries: bySection[section]}).writeFlat(b, INI)
+	}
+	if d.footer != "" {
+		b.WriteByte('\n')
+		writeComment(b, d.footer)
+	}
+}
+
+// iniSection returns the section of key, and its name in the section.
+func iniSection(key string) (section, name string) {
+	if i := strings.IndexByte(key, '_'); i > 0 && i < len(key)-1 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// writeJSON writes the document as a JSON object.
+func (d *document) writeJSON(b *bytes.Buffer) {
+	n := 0
+	start := func(name string) {
+		if n != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString("\n  ")
+		b.WriteString(jsonQuote(name))
+		b.WriteString(": ")
+		n++
+	}
+	member := func(name, value string) {
+		start(name)
+		b.WriteString(jsonQuote(value))
+	}
+
+	b.WriteByte('{')
+	if d.header != "" {
+		member("#", d.header)
+	}
+	for _, e := range d.entries {
+		comment := e.comment
+		if e.trailing != "" {
+			if comment != "" {
+				comment += "\n"
+			}
+			comment += e.trailing
+		}
+		if comment != "" {
+			member("#"+e.key, comment)
+		}
+		if e.array == noArray {
+			member(e.key, e.value)
+			continue
+		}
+
+		start(e.key)
+		open, close := byte('['), byte(']')
+		if e.array == assocArray {
+			open, close = '{', '}'
+		}
+		b.WriteByte(open)
+		for i, el := range e.elems {
+			if i != 0 {
+				b.WriteString(", ")
+			}
+			if e.array == assocArray {
+				b.WriteString(jsonQuote(el.key) + ": ")
+			}
+			b.WriteString(jsonQuote(el.value))
+		}
+		b.WriteByte(close)
+	}
+	if d.footer != "" {
+		member("#/", d.footer)
+	}
+	if n != 0 {
+		b.WriteByte('\n')
+	}
+	b.WriteString("}\n")
+}
+
+// jsonQuote returns s as a JSON string, without escaping HTML characters.
+func jsonQuote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// dquote returns s quoted with double quotes, with the escapes which are
+// valid in TOML and YAML.
+func dquote(s string) string {
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// iniQuote returns s quoted with double quotes when it has characters which
+// would not be read back as they are.
+func iniQuote(s string) string {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s, "\"'#;\\") {
+		return dquote(s)
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return dquote(s)
+		}
+	}
+	return s
+}
+
+// readJSON reads a document from a JSON object, whose values have to be
+// strings, numbers, booleans or null, or arrays or objects of them.
+func readJSON(r io.Reader) (*document, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, errors.New("shconf: JSON configuration is not an object")
+	}
+
+	var b docBuilder
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name := t.(string)
+
+		if t, err = dec.Token(); err != nil {
+			return nil, err
+		}
+		e := entry{key: name}
+		switch t {
+		case json.Delim('['):
+			e.array = indexedArray
+		case json.Delim('{'):
+			e.array = assocArray
+		default:
+			if e.value, err = jsonScalar(t, name); err != nil {
+				return nil, err
+			}
+		}
+		if e.array != noArray {
+			if e.elems, err = readJSONArray(dec, name, e.array); err != nil {
+				return nil, err
+			}
+			e.value = joinValues(e.elems)
+		}
+
+		switch {
+		case name == "#":
+			b.d.header = e.value
+		case strings.HasPrefix(name, "#"): // "#KEY", or "#/" at the end
+			b.comment = []string{e.value}
+		default:
+			if !isName(name) {
+				return nil, errors.New("shconf: invalid key name " + name)
+			}
+			b.addEntry(e)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return b.document(), nil
+}
+
+// jsonScalar returns the text of the JSON token t, the value of the member
+// name.
+func jsonScalar(t json.Token, name string) (string, error) {
+	switch v := t.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	}
+	return "", errors.New("shconf: JSON member " + name + ": nested values are not supported")
+}
+
+// readJSONArray reads the elements of a JSON array, or of an object for an
+// associative array, after of its first token.
+func readJSONArray(dec *json.Decoder, name string, kind arrayKind) ([]element, error) {
+	var elems []element
+	for dec.More() {
+		var el element
+		if kind == assocArray {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			el.key = t.(string)
+		}
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if el.value, err = jsonScalar(t, name); err != nil {
+			return nil, err
+		}
+		elems = append(elems, el)
+	}
+	if _, err := dec.Token(); err != nil { // end of the array
+		return nil, err
+	}
+	return elems, nil
+}
+
+// joinValues returns the values of the elements joined by spaces, such as
+// the value of an array in a Config.
+func joinValues(elems []element) string {
+	values := make([]string, len(elems))
+	for i, e := range elems {
+		values[i] = e.value
+	}
+	return strings.Join(values, " ")
+}
+
+// readFlat reads a document in the format f, which is INI, TOML or YAML.
+func readFlat(r io.Reader, f Format) (*document, error) {
+	var b docBuilder
+	var section string
+	sep := byte('=')
+	if f == YAML {
+		sep = ':'
+	}
+
+	scan := bufio.NewScanner(r)
+	for n := 1; scan.Scan(); n++ {
+		errorf := func(format string, args ...interface{}) error {
+			return &ParseError{Line: n, Reason: fmt.Sprintf(format, args...)}
+		}
+		line := scan.Text()
+		text := strings.TrimSpace(line)
+
+		switch {
+		case text == "":
+			b.addBlank()
+			continue
+		case text[0] == '#' || (f == INI && text[0] == ';'):
+			b.addComment(strings.TrimSpace(text[1:]))
+			continue
+		case f == YAML && (text == "---" || text == "..."):
+			continue
+		case text[0] == '[':
+			if f != INI || !strings.HasSuffix(text, "]") {
+				return nil, errorf("tables are not supported: %s", text)
+			}
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			if !isName(section) {
+				return nil, errorf("invalid section name %s", section)
+			}
+			continue
+		}
+		if f == YAML && (line[0] == ' ' || line[0] == '\t') {
+			return nil, errorf("nested values are not supported")
+		}
+
+		i := strings.IndexByte(text, sep)
+		if i == -1 {
+			return nil, errorf("missing %q after key", sep)
+		}
+		key := strings.TrimSpace(text[:i])
+		if section != "" {
+			key = section + "_" + key
+		}
+		if !isName(key) {
+			return nil, errorf("invalid key name %s", key)
+		}
+
+		e, err := readValue(strings.TrimSpace(text[i+1:]), f)
+		if err != nil {
+			return nil, errorf("key %s: %s", key, err)
+		}
+		e.key = key
+		b.addEntry(e)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	return b.document(), nil
+}
+
+// readValue returns the entry with the value at the beginning of text, and
+// the comment after of it.
+func readValue(text string, f Format) (e entry, err error) {
+	var rest string
+
+	switch {
+	case text == "":
+	case (text[0] == '[' || text[0] == '{') && f != INI:
+		if e, rest, err = readArray(text, f); err != nil {
+			return e, err
+		}
+	case text[0] == '"' || (text[0] == '\'' && f != INI):
+		if e.value, rest, err = readQuoted(text, f); err != nil {
+			return e, err
+		}
+
+	default:
+		if f == INI {
+			e.value = text
+			return e, nil
+		}
+		e.value = text
+		if i := strings.Index(text, " #"); i != -1 {
+			e.value, rest = strings.TrimSpace(text[:i]), text[i:]
+		} else if f == TOML && strings.HasPrefix(text, "#") {
+			e.value, rest = "", text
+		}
+	}
+
+	rest = strings.TrimSpace(rest)
+	switch {
+	case rest == "":
+	case rest[0] == '#' || (f == INI && rest[0] == ';'):
+		e.trailing = strings.TrimSpace(rest[1:])
+	default:
+		return e, errors.New("unexpected text after value: " + rest)
+	}
+	return e, nil
+}
+
+// readQuoted returns the string quoted at the beginning of text, and the rest
+// of text after of it.
+func readQuoted(text string, f Format) (value, rest string, err error) {
+	if text[0] == '"' {
+		end := 1
+		for ; end < len(text) && text[end] != '"'; end++ {
+			if text[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(text) {
+			return "", "", errors.New("unterminated double quote")
+		}
+		if value, err = strconv.Unquote(text[:end+1]); err != nil {
+			return "", "", errors.New("invalid string " + text[:end+1])
+		}
+		return value, text[end+1:], nil
+	}
+
+	i := 1
+	for {
+		end := strings.IndexByte(text[i:], '\'')
+		if end == -1 {
+			return "", "", errors.New("unterminated single quote")
+		}
+		value += text[i : i+end]
+		i += end + 1
+		// YAML escapes the single quote by doubling it.
+		if f == YAML && i < len(text) && text[i] == '\'' {
+			value += "'"
+			i++
+			continue
+		}
+		break
+	}
+	return value, text[i:], nil
+}
+
+// readArray returns the entry with the array at the beginning of text, as
+// "[a, b]", or with the associative array as "{k: v}" in YAML and
+// "{ k = v }" in TOML; it returns the rest of text after of it.
+func readArray(text string, f Format) (e entry, rest string, err error) {
+	e.array, rest = indexedArray, text[1:]
+	close := byte(']')
+	if text[0] == '{' {
+		e.array, close = assocArray, '}'
+	}
+	keySep := byte(':')
+	if f == TOML {
+		keySep = '='
+	}
+
+	// item returns the string at the beginning of rest, quoted or bare until
+	// one of the characters in stop.
+	item := func(stop string) (string, error) {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			var s string
+			s, rest, err = readQuoted(rest, f)
+			return s, err
+		}
+		i := strings.IndexAny(rest, stop)
+		if i == -1 {
+			return "", errors.New("unterminated array")
+		}
+		s := strings.TrimSpace(rest[:i])
+		if s == "" || s[0] == '[' || s[0] == '{' {
+			return "", errors.New("nested values are not supported")
+		}
+		rest = rest[i:]
+		return s, nil
+	}
+
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			return e, "", errors.New("unterminated array")
+		}
+		if rest[0] == close {
+			break
+		}
+
+		var el element
+		if e.array == assocArray {
+			if el.key, err = item(string(keySep)); err != nil {
+				return e, "", err
+			}
+			rest = strings.TrimLeft(rest, " \t")
+			if rest == "" || rest[0] != keySep {
+				return e, "", fmt.Errorf("missing %q after key in array", keySep)
+			}
+			rest = rest[1:]
+		}
+		if el.value, err = item(",]}"); err != nil {
+			return e, "", err
+		}
+		e.elems = append(e.elems, el)
+
+		rest = strings.TrimLeft(rest, " \t")
+		if rest != "" && rest[0] == ',' {
+			rest = rest[1:]
+		} else if rest == "" || rest[0] != close {
+			return e, "", errors.New("missing ',' between elements of array")
+		}
+	}
+	e.value = joinValues(e.elems)
+	return e, rest[1:], nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var convertFiles = map[Format]string{
+	JSON: "testdata/convert.json",
+	INI:  "testdata/convert.ini",
+	TOML: "testdata/convert.toml",
+	YAML: "testdata/convert.yaml",
+}
+
+func TestConvert(t *testing.T) {
+	cfg, err := ParseFile("testdata/convert.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for f, golden := range convertFiles {
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		if err = cfg.Export(&b, f); err != nil {
+			t.Errorf("%s: %s", f, err)
+			continue
+		}
+		if b.String() != string(want) {
+			t.Errorf("%s: Export got\n%s\nwant\n%s", f, b.String(), want)
+		}
+
+		// The conversion back gets the same keys, values and output.
+		cfg2, err := Import(bytes.NewReader(want), f)
+		if err != nil {
+			t.Errorf("%s: %s", f, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg2.data, cfg.data) {
+			t.Errorf("%s: Import got %v, want %v", f, cfg2.data, cfg.data)
+		}
+		b.Reset()
+		cfg2.Export(&b, f)
+		if b.String() != string(want) {
+			t.Errorf("%s: Export after Import got\n%s", f, b.String())
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	for _, tt := range []struct {
+		f    Format
+		in   string
+		want string
+	}{
+		{TOML, "# c\nA = 'x \"y\"'\nB = 42 # n\nC = true\n", "# c\nA=\"x \\\"y\\\"\"\nB=42 # n\nC=true\n"},
+		{YAML, "---\nA: it''s\nB: 'it''s'\nC: plain text # c\nD:\n", "A=\"it''s\"\nB=\"it's\"\nC=\"plain text\" # c\nD=\"\"\n"},
+		{INI, "; c\nA = 1 ; 2\n[S]\nB = \"x\" ; c\n", "# c\nA=\"1 ; 2\"\nS_B=x # c\n"},
+		{JSON, `{"#A": "c", "A": 1.5, "B": null, "C": false}`, "# c\nA=1.5\nB=\"\"\nC=false\n"},
+		{JSON, `{"A": [1, "x y"], "B": {"k": true}}`, "A=(1 \"x y\")\ndeclare -A B=([k]=true)\n"},
+		{TOML, "A = [ 'x', \"y\", ]\nB = {}\n", "A=(x y)\ndeclare -A B=()\n"},
+		{YAML, "A: [x y, z] # c\nB: {k: 'v'}\n", "A=(\"x y\" z) # c\ndeclare -A B=([k]=v)\n"},
+	} {
+		cfg, err := Import(strings.NewReader(tt.in), tt.f)
+		if err != nil {
+			t.Errorf("%s %q: %s", tt.f, tt.in, err)
+			continue
+		}
+		var b bytes.Buffer
+		cfg.WriteTo(&b)
+		if b.String() != tt.want {
+			t.Errorf("%s %q got %q, want %q", tt.f, tt.in, b.String(), tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		f   Format
+		in  string
+		err string
+	}{
+		{TOML, "A = 1\n[table]\n", "line 2: tables are not supported: [table]"},
+		{TOML, "A = \"x\n", "line 1: key A: unterminated double quote"},
+		{TOML, "A-B = 1\n", "line 1: invalid key name A-B"},
+		{YAML, "A:\n  B: 1\n", "line 2: nested values are not supported"},
+		{YAML, "A: 'x' y\n", "line 1: key A: unexpected text after value: y"},
+		{INI, "[1]\n", "line 1: invalid section name 1"},
+		{INI, "A\n", "line 1: missing '=' after key"},
+		{JSON, `[1]`, "shconf: JSON configuration is not an object"},
+		{JSON, `{"A": {"B": {"C": 1}}}`, "shconf: JSON member A: nested values are not supported"},
+		{JSON, `{"A": [[1]]}`, "shconf: JSON member A: nested values are not supported"},
+		{TOML, "A = [[1]]\n", "line 1: key A: nested values are not supported"},
+		{TOML, "A = [\"x\" \"y\"]\n", "line 1: key A: missing ',' between elements of array"},
+		{YAML, "A: [x, y\n", "line 1: key A: unterminated array"},
+	} {
+		_, err := Import(strings.NewReader(tt.in), tt.f)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s %q got error %v, want %q", tt.f, tt.in, err, tt.err)
+		}
+	}
+}
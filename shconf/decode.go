@@ -0,0 +1,329 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A FieldError describes an error at decoding the value of a key into a field
+// of a struct.
+type FieldError struct {
+	Field string // name of the field, with the names of the outer structs
+	Key   string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return "field " + e.Field + " (key " + e.Key + "): " + e.Err.Error()
+}
+
+// FieldErrors is the list of errors returned by Unmarshal.
+type FieldErrors []*FieldError
+
+func (l FieldErrors) Error() string {
+	var b bytes.Buffer
+	for i, e := range l {
+		if i != 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(e.Error())
+	}
+	return b.String()
+}
+
+// ErrRequired is used in a FieldError when a required key is not found.
+var ErrRequired = errors.New("required key not found")
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
+)
+
+// Unmarshal stores the values of the configuration in the struct pointed to
+// by v.
+//
+// Every exported field is set from the key given in its "shconf" tag, or from
+// the key with the name of the field if there is no tag. The tag can also
+// have the options:
+//
+//	required     the key has to be in the configuration
+//	default=...  value to use when the key is not in the configuration; it
+//	             has to be the last option, since the value can have commas
+//	sep=...      separator of the elements for a slice; by default they
+//	             are separated by commas, blanks or both
+//	array        the slice is written by Marshal as an array, as "(a b)"
+//
+// as in:
+//
+//	Port    int           `shconf:"PORT,default=80"`
+//	Timeout time.Duration `shconf:"TIMEOUT,required"`
+//	Modules []string      `shconf:"MODULES,sep=:"`
+//	Other   string        `shconf:"-"` // ignored
+//
+// The supported types are the booleans, integers, floats, complex numbers
+// and strings, time.Duration, the types which implement
+// encoding.TextUnmarshaler, and slices of them. An array is decoded into a
+// slice, and an associative array into a map with string keys.
+//
+// The fields of a struct are decoded recursively; their keys are prefixed
+// with the name in the tag of the struct, as "DB_" in:
+//
+//	DB struct {
+//		Host string `shconf:"HOST"` // from key DB_HOST
+//	} `shconf:"DB_"`
+//
+// The fields of an embedded struct without tag have no prefix.
+//
+// The errors found in every field are returned together as FieldErrors.
+func Unmarshal(cfg *Config, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("shconf: Unmarshal needs a non-nil pointer to a struct")
+	}
+
+	cfg.RLock()
+	defer cfg.RUnlock()
+
+	var errs FieldErrors
+	cfg.decodeStruct(rv.Elem(), "", "", &errs)
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// A fieldTag represents the tag "shconf" of a field.
+type fieldTag struct {
+	key      string
+	required bool
+	hasDef   bool
+	def      string
+	sep      string
+	array    bool
+}
+
+// parseTag parses the tag of a field. The option default has to be the last
+// one, since its value could have commas.
+func parseTag(tag string) (fieldTag, error) {
+	opts := strings.Split(tag, ",")
+	t := fieldTag{key: opts[0]}
+
+	for i := 1; i < len(opts); i++ {
+		switch opt := opts[i]; {
+		case opt == "required":
+			t.required = true
+		case strings.HasPrefix(opt, "default="):
+			for _, o := range opts[i+1:] {
+				if isTagOption(o) {
+					return t, errors.New("option " + o + " after default in tag; default has to be the last option")
+				}
+			}
+			t.hasDef = true
+			t.def = strings.Join(append([]string{opt[8:]}, opts[i+1:]...), ",")
+			i = len(opts)
+		case strings.HasPrefix(opt, "sep="):
+			t.sep = opt[4:]
+		case opt == "array":
+			t.array = true
+		}
+	}
+	return t, nil
+}
+
+// isTagOption reports whether opt is an option of the tag.
+func isTagOption(opt string) bool {
+	return opt == "required" || opt == "array" ||
+		strings.HasPrefix(opt, "default=") || strings.HasPrefix(opt, "sep=")
+}
+
+// decodeStruct sets the fields of the struct v, from the keys with the given
+// prefix; path is the name of v, for the errors.
+func (c *Config) decodeStruct(v reflect.Value, prefix, path string, errs *FieldErrors) {
+	typ := v.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" && !field.Anonymous { // unexported
+			continue
+		}
+		tagValue, hasTag := field.Tag.Lookup("shconf")
+		if tagValue == "-" {
+			continue
+		}
+		tag, err := parseTag(tagValue)
+		if err != nil {
+			*errs = append(*errs, &FieldError{path + field.Name, prefix + tag.key, err})
+			continue
+		}
+		fv := v.Field(i)
+
+		if isStruct(field.Type) {
+			p := tag.key
+			if !hasTag && !field.Anonymous {
+				p = field.Name + "_"
+			}
+			c.decodeStruct(fv, prefix+p, path+field.Name+".", errs)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		key := tag.key
+		if key == "" {
+			key = field.Name
+		}
+		key = prefix + key
+
+		if line := c.line(key); line != nil && line.array != noArray &&
+			(fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map) {
+			if err := setArray(fv, line); err != nil {
+				*errs = append(*errs, &FieldError{path + field.Name, key, err})
+			}
+			continue
+		}
+
+		value, found := c.data[key]
+		if !found {
+			if tag.required {
+				*errs = append(*errs, &FieldError{path + field.Name, key, ErrRequired})
+				continue
+			}
+			if !tag.hasDef {
+				continue
+			}
+			value = tag.def
+		}
+
+		if err := setValue(fv, value, tag.sep); err != nil {
+			*errs = append(*errs, &FieldError{path + field.Name, key, err})
+		}
+	}
+}
+
+// isStruct reports whether typ is a struct to decode field by field.
+func isStruct(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Struct && !reflect.PtrTo(typ).Implements(textUnmarshalerType)
+}
+
+// setValue sets v from the text of a value; sep is the separator of the
+// elements of a slice.
+func setValue(v reflect.Value, s, sep string) error {
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Complex64, reflect.Complex128:
+		n, err := strconv.ParseComplex(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetComplex(n)
+	case reflect.String:
+		v.SetString(s)
+
+	case reflect.Slice:
+		elems := splitList(s, sep)
+		slice := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+		for i, e := range elems {
+			if err := setValue(slice.Index(i), e, ""); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+
+	default:
+		return errors.New("unsupported type " + v.Type().String())
+	}
+	return nil
+}
+
+// setArray sets the slice or map v from the elements of the array in line.
+func setArray(v reflect.Value, line *logicalLine) error {
+	if v.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(v.Type(), len(line.elems), len(line.elems))
+		for i, e := range line.elems {
+			if err := setValue(slice.Index(i), e.value, ""); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	if line.array != assocArray {
+		return errors.New("indexed array in map")
+	}
+	if v.Type().Key().Kind() != reflect.String {
+		return errors.New("unsupported type " + v.Type().String())
+	}
+	m := reflect.MakeMapWithSize(v.Type(), len(line.elems))
+	for _, e := range line.elems {
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := setValue(elem, e.value, ""); err != nil {
+			return err
+		}
+		m.SetMapIndex(reflect.ValueOf(e.key).Convert(v.Type().Key()), elem)
+	}
+	v.Set(m)
+	return nil
+}
+
+// splitList splits a list of elements separated by sep or, if it is empty,
+// by commas, blanks or both.
+func splitList(s, sep string) []string {
+	if sep != "" {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, sep)
+	}
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
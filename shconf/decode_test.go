@@ -0,0 +1,157 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Common struct {
+	Debug bool `shconf:"DEBUG"`
+}
+
+type testConfig struct {
+	Common
+	Name    string        `shconf:"NAME,required"`
+	Port    uint16        `shconf:"PORT,default=80"`
+	Ratio   float32       `shconf:"RATIO"`
+	Offset  int8          `shconf:"OFFSET"`
+	Mode    int           `shconf:"MODE"`
+	Point   complex128    `shconf:"POINT"`
+	Timeout time.Duration `shconf:"TIMEOUT"`
+	Modules []string      `shconf:"MODULES"`
+	Path    []string      `shconf:"PATH,sep=:"`
+	Ports   []int         `shconf:"PORTS,default=1,2"`
+	IP      net.IP        `shconf:"IP"`
+	Ignored string        `shconf:"-"`
+	Plain   string
+
+	DB struct {
+		Host string `shconf:"HOST"`
+		Port int    `shconf:"PORT"`
+	} `shconf:"DB_"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	cfg, err := ParseString(`
+DEBUG=true
+NAME="my server"
+RATIO=0.5
+OFFSET=-3
+MODE=010
+POINT="(1+2i)"
+TIMEOUT=1m30s
+MODULES="a, b c"
+PATH=/bin:/usr/bin
+IP=10.0.0.1
+Ignored=x
+Plain=plain
+DB_HOST=db.local
+DB_PORT=5432
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got testConfig
+	if err = Unmarshal(cfg, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	var want testConfig
+	want.Debug = true
+	want.Name = "my server"
+	want.Port = 80
+	want.Ratio = 0.5
+	want.Offset = -3
+	want.Mode = 10 // in base 10, such as Config.Int
+	want.Point = 1 + 2i
+	want.Timeout = 90 * time.Second
+	want.Modules = []string{"a", "b", "c"}
+	want.Path = []string{"/bin", "/usr/bin"}
+	want.Ports = []int{1, 2}
+	want.IP = net.ParseIP("10.0.0.1")
+	want.Plain = "plain"
+	want.DB.Host = "db.local"
+	want.DB.Port = 5432
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+	if n, err := cfg.Int("MODE"); err != nil || n != got.Mode {
+		t.Errorf("Int got %d, %v; Unmarshal got %d", n, err, got.Mode)
+	}
+
+	cfg, _ = ParseString("MODE=0x1F\n")
+	if err = Unmarshal(cfg, &got); err == nil {
+		t.Error("expected error for an hexadecimal number")
+	}
+}
+
+func TestTagDefault(t *testing.T) {
+	cfg, err := ParseString("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v struct {
+		Names string `shconf:"NAMES,default=a,b"`
+	}
+	if err = Unmarshal(cfg, &v); err != nil || v.Names != "a,b" {
+		t.Errorf("got %q, %v", v.Names, err)
+	}
+
+	var bad struct {
+		Port int    `shconf:"PORT,default=5,required"`
+		Name string `shconf:"NAME,default=x,sep=:"`
+	}
+	err = Unmarshal(cfg, &bad)
+	errs, ok := err.(FieldErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected 2 FieldErrors, got %v", err)
+	}
+	if got := errs[0].Err.Error(); got != "option required after default in tag; default has to be the last option" {
+		t.Errorf("got error %q", got)
+	}
+	if _, err = Marshal(&bad); err == nil {
+		t.Error("Marshal: expected error for option after default")
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	cfg, err := ParseString("PORT=http\nOFFSET=300\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v testConfig
+	err = Unmarshal(cfg, &v)
+	errs, ok := err.(FieldErrors)
+	if !ok {
+		t.Fatalf("expected FieldErrors, got %v", err)
+	}
+
+	want := map[string]string{"Name": "NAME", "Port": "PORT", "Offset": "OFFSET"}
+	if len(errs) != len(want) {
+		t.Errorf("got %d errors, want %d: %s", len(errs), len(want), errs)
+	}
+	for _, e := range errs {
+		if want[e.Field] != e.Key {
+			t.Errorf("unexpected error: %s", e)
+		}
+	}
+	if errs[0].Err != ErrRequired {
+		t.Errorf("got %v, want %v", errs[0].Err, ErrRequired)
+	}
+
+	if err = Unmarshal(cfg, v); err == nil {
+		t.Error("expected error with a non-pointer")
+	}
+}
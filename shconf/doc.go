@@ -0,0 +1,175 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"strings"
+)
+
+// The comments are the documentation of the configuration. The comment at the
+// beginning of the file, followed by a blank line, is about the file. The
+// comment lines just above a key, and the comment after its value, are about
+// the key. The keys are grouped under the comments above them:
+//
+//	# Server.
+//
+//	# Network.
+//	HOST=localhost
+//	PORT=80  # port to listen
+//
+//	# Logging.
+//	LOG=/var/log/server.log
+
+// A Group is a comment with the keys under it.
+type Group struct {
+	Comment string
+	Keys    []string
+}
+
+// Doc returns the comment about the file.
+func (c *Config) Doc() string {
+	c.RLock()
+	defer c.RUnlock()
+	if len(c.comment) == 0 {
+		return ""
+	}
+	return c.comment[0].Comment
+}
+
+// Groups returns the groups of keys in the order of the file. The keys before
+// of the first comment are in a group with an empty comment.
+func (c *Config) Groups() []Group {
+	c.RLock()
+	defer c.RUnlock()
+
+	var groups []Group
+	for i := 1; i < len(c.comment); i++ {
+		g := c.comment[i]
+		groups = append(groups, Group{g.Comment, append([]string(nil), g.Keys...)})
+	}
+	return groups
+}
+
+// KeyDoc returns the comment lines just above key, followed by the comment
+// after its value. A key set in other file has only the comment after its
+// value.
+func (c *Config) KeyDoc(key string) (string, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	line := c.external(key)
+	doc := ""
+	if line == nil {
+		if line = c.find(key); line == nil {
+			return "", &KeyNotFoundError{key}
+		}
+		doc = c.docAbove(line)
+	}
+	if line.comment != "" {
+		if doc != "" {
+			doc += "\n"
+		}
+		doc += line.comment
+	}
+	return doc, nil
+}
+
+// SetKeyDoc replaces the comment lines just above key by doc, or removes them
+// if doc is empty. The comment after the value is kept. The change is only
+// done in memory, such as in SetValue.
+func (c *Config) SetKeyDoc(key, doc string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if err := c.checkLocal(key); err != nil {
+		return err
+	}
+	i := c.index(key)
+	if i == -1 {
+		return &KeyNotFoundError{key}
+	}
+
+	j := i
+	for j > 0 && c.lines[j-1].kind == commentLine {
+		j--
+	}
+	lines := append([]*logicalLine(nil), c.lines[:j]...)
+	if doc != "" {
+		for _, text := range strings.Split(doc, "\n") {
+			lines = append(lines, newComment(text))
+		}
+	}
+	c.lines = append(lines, c.lines[i:]...)
+	c.update()
+	return nil
+}
+
+// newComment returns a new comment line.
+func newComment(text string) *logicalLine {
+	raw := "#\n"
+	if text = strings.TrimSpace(text); text != "" {
+		raw = "# " + text + "\n"
+	}
+	return &logicalLine{kind: commentLine, raw: []byte(raw), comment: text}
+}
+
+// docAbove returns the text of the comment lines just above line.
+func (c *Config) docAbove(line *logicalLine) string {
+	i := 0
+	for i < len(c.lines) && c.lines[i] != line {
+		i++
+	}
+	j := i
+	for j > 0 && c.lines[j-1].kind == commentLine {
+		j--
+	}
+
+	var doc []string
+	for ; j < i; j++ {
+		doc = append(doc, strings.TrimSpace(c.lines[j].comment))
+	}
+	return strings.Join(doc, "\n")
+}
+
+// groupComments returns the comments of the lines, with the keys under every
+// comment. The first group is for the comment about the file, which has no
+// keys.
+func groupComments(lines []*logicalLine) []Group {
+	group := []Group{{}}
+	var comment []string
+
+	for _, line := range lines {
+		switch line.kind {
+		case sourceLine:
+			continue
+		case blankLine:
+			// The comment at the beginning, followed by a blank line, is
+			// about the file.
+			if len(group) == 1 && group[0].Comment == "" && len(comment) != 0 {
+				group[0].Comment = strings.Join(comment, "\n")
+				comment = comment[:0]
+			}
+			continue
+		case commentLine:
+			comment = append(comment, strings.TrimSpace(line.comment))
+			continue
+		}
+
+		// The keys before of the first comment are in a group with an empty
+		// comment.
+		if len(comment) != 0 || len(group) == 1 {
+			group = append(group, Group{Comment: strings.Join(comment, "\n")})
+			comment = comment[:0]
+		}
+		g := &group[len(group)-1]
+		g.Keys = append(g.Keys, line.key)
+	}
+	return group
+}
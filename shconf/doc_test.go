@@ -0,0 +1,140 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const docFile = `# Server.
+# Version 1.
+
+NAME=srv
+
+# Network.
+#
+HOST=localhost
+PORT=80  # port to listen
+
+# Logging.
+LOG=/var/log/srv.log
+LEVEL=info # debug, info or error
+`
+
+func TestDoc(t *testing.T) {
+	cfg, err := ParseString(docFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cfg.Doc(), "Server.\nVersion 1."; got != want {
+		t.Errorf("Doc got %q, want %q", got, want)
+	}
+
+	wantGroups := []Group{
+		{"", []string{"NAME"}},
+		{"Network.\n", []string{"HOST", "PORT"}},
+		{"Logging.", []string{"LOG", "LEVEL"}},
+	}
+	if got := cfg.Groups(); !reflect.DeepEqual(got, wantGroups) {
+		t.Errorf("Groups got %q, want %q", got, wantGroups)
+	}
+
+	for _, tt := range []struct {
+		key, doc string
+	}{
+		{"NAME", ""},
+		{"HOST", "Network.\n"},
+		{"PORT", "port to listen"},
+		{"LOG", "Logging."},
+		{"LEVEL", "debug, info or error"},
+	} {
+		doc, err := cfg.KeyDoc(tt.key)
+		if err != nil {
+			t.Errorf("KeyDoc(%q) got error: %s", tt.key, err)
+		} else if doc != tt.doc {
+			t.Errorf("KeyDoc(%q) got %q, want %q", tt.key, doc, tt.doc)
+		}
+	}
+	if _, err = cfg.KeyDoc("NONE"); !isNotFound(err) {
+		t.Errorf("KeyDoc got error %v, want key not found", err)
+	}
+
+	// Without comment about the file.
+	cfg, _ = ParseString("A=1\n# B.\nB=2\n")
+	if got := cfg.Doc(); got != "" {
+		t.Errorf("Doc got %q, want empty", got)
+	}
+	wantGroups = []Group{{"", []string{"A"}}, {"B.", []string{"B"}}}
+	if got := cfg.Groups(); !reflect.DeepEqual(got, wantGroups) {
+		t.Errorf("Groups got %q, want %q", got, wantGroups)
+	}
+}
+
+func TestEditDoc(t *testing.T) {
+	cfg, err := ParseString(docFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cfg.SetValue("PORT", "8080"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.RenameKey("LEVEL", "LOG_LEVEL"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.AddKey("TIMEOUT", "30", "PORT"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.SetKeyDoc("TIMEOUT", "Seconds to wait."); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.SetKeyDoc("LOG", "Log file.\nIt is rotated."); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.SetKeyDoc("HOST", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.SetKeyDoc("NONE", "x"); !isNotFound(err) {
+		t.Errorf("SetKeyDoc got error %v, want key not found", err)
+	}
+
+	want := `# Server.
+# Version 1.
+
+NAME=srv
+
+HOST=localhost
+PORT=8080  # port to listen
+# Seconds to wait.
+TIMEOUT=30
+
+# Log file.
+# It is rotated.
+LOG=/var/log/srv.log
+LOG_LEVEL=info # debug, info or error
+`
+	var b bytes.Buffer
+	cfg.WriteTo(&b)
+	if b.String() != want {
+		t.Errorf("WriteTo got\n%s\nwant\n%s", b.String(), want)
+	}
+
+	wantGroups := []Group{
+		{"", []string{"NAME", "HOST", "PORT"}},
+		{"Seconds to wait.", []string{"TIMEOUT"}},
+		{"Log file.\nIt is rotated.", []string{"LOG", "LOG_LEVEL"}},
+	}
+	if got := cfg.Groups(); !reflect.DeepEqual(got, wantGroups) {
+		t.Errorf("Groups got %q, want %q", got, wantGroups)
+	}
+	if doc, _ := cfg.KeyDoc("LOG_LEVEL"); doc != "debug, info or error" {
+		t.Errorf("KeyDoc got %q", doc)
+	}
+}
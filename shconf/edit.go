@@ -0,0 +1,272 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// The Config keeps every line of the file, with its comments and blank lines,
+// so it can be edited and written back. The lines which are not edited are
+// written such as they were read.
+
+// SetValue sets a new value for key. The change is only done in memory; use
+// WriteTo or WriteValue to write it.
+func (c *Config) SetValue(key, value string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if err := c.checkLocal(key); err != nil {
+		return err
+	}
+	line := c.find(key)
+	if line == nil {
+		return &KeyNotFoundError{key}
+	}
+	if line.array != noArray {
+		return errArray(key)
+	}
+	line.setValue(value)
+	c.data[key] = value
+	return nil
+}
+
+// AddKey adds a new key with its value after the key named after, or at the
+// end of the file when after is empty.
+func (c *Config) AddKey(key, value, after string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if err := c.checkNewKey(key); err != nil {
+		return err
+	}
+
+	i := len(c.lines)
+	if after != "" {
+		if i = c.index(after); i == -1 {
+			return &KeyNotFoundError{after}
+		}
+		i++
+	}
+	c.insert(i, newAssign(key, value))
+	c.data[key] = value
+	return nil
+}
+
+// AddKeyUnder adds a new key with its value at the end of the block of keys
+// under the comment which contains the text comment.
+func (c *Config) AddKeyUnder(key, value, comment string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if err := c.checkNewKey(key); err != nil {
+		return err
+	}
+
+	i := 0
+	for ; i < len(c.lines); i++ {
+		if c.lines[i].kind == commentLine && strings.Contains(c.lines[i].comment, comment) {
+			break
+		}
+	}
+	if i == len(c.lines) {
+		return errors.New("comment not found: " + comment)
+	}
+
+	// Skip the rest of the comment, and the keys under it.
+	for i++; i < len(c.lines) && c.lines[i].kind == commentLine; i++ {
+	}
+	for ; i < len(c.lines) && c.lines[i].kind == assignLine; i++ {
+	}
+
+	c.insert(i, newAssign(key, value))
+	c.data[key] = value
+	return nil
+}
+
+// DeleteKey removes the line of key.
+func (c *Config) DeleteKey(key string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if err := c.checkLocal(key); err != nil {
+		return err
+	}
+	i := c.index(key)
+	if i == -1 {
+		return &KeyNotFoundError{key}
+	}
+	c.lines = append(c.lines[:i], c.lines[i+1:]...)
+	delete(c.data, key)
+	c.update()
+	return nil
+}
+
+// RenameKey changes the name of the key oldKey to newKey, keeping its value.
+func (c *Config) RenameKey(oldKey, newKey string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if err := c.checkLocal(oldKey); err != nil {
+		return err
+	}
+	line := c.find(oldKey)
+	if line == nil {
+		return &KeyNotFoundError{oldKey}
+	}
+	if err := c.checkNewKey(newKey); err != nil {
+		return err
+	}
+
+	line.rename(newKey)
+	c.data[newKey] = c.data[oldKey]
+	delete(c.data, oldKey)
+	c.update()
+	return nil
+}
+
+// WriteTo writes the configuration to w. The lines which have not been
+// edited are written byte for byte such as they were read.
+func (c *Config) WriteTo(w io.Writer) (n int64, err error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.closed {
+		return 0, ErrClosed
+	}
+	return c.writeTo(w)
+}
+
+func (c *Config) writeTo(w io.Writer) (n int64, err error) {
+	for _, line := range c.lines {
+		nn, err := w.Write(line.raw)
+		n += int64(nn)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// find returns the line where key is assigned, or nil.
+func (c *Config) find(key string) *logicalLine {
+	if i := c.index(key); i != -1 {
+		return c.lines[i]
+	}
+	return nil
+}
+
+// index returns the index of the line where key is assigned, or -1.
+func (c *Config) index(key string) int {
+	for i, line := range c.lines {
+		if line.kind == assignLine && line.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkNewKey checks that key can be added to the configuration.
+func (c *Config) checkNewKey(key string) error {
+	if !isName(key) {
+		return errors.New("invalid key name: " + key)
+	}
+	if _, found := c.data[key]; found {
+		return errors.New("key already exists: " + key)
+	}
+	return nil
+}
+
+// insert inserts line at index i.
+func (c *Config) insert(i int, line *logicalLine) {
+	// The previous line could be the last one of the file, without a newline.
+	if i > 0 {
+		if prev := c.lines[i-1]; !bytes.HasSuffix(prev.raw, []byte{'\n'}) {
+			prev.raw = append(prev.raw[:len(prev.raw):len(prev.raw)], '\n')
+			prev.tail = append(prev.tail[:len(prev.tail):len(prev.tail)], '\n')
+		}
+	}
+
+	c.lines = append(c.lines, nil)
+	copy(c.lines[i+1:], c.lines[i:])
+	c.lines[i] = line
+	c.update()
+}
+
+// update sets the number and offset of every line, and the comment groups,
+// after of an edition.
+func (c *Config) update() {
+	n, off := 1, int64(0)
+	for _, line := range c.lines {
+		line.line, line.off = n, off
+		n += bytes.Count(line.raw, []byte{'\n'})
+		off += int64(len(line.raw))
+	}
+	c.comment = groupComments(c.lines)
+}
+
+// rename replaces the key in the text of the assignment; the value is kept
+// such as it is in the file, with its quotes and references.
+func (l *logicalLine) rename(key string) {
+	n := len(l.prefix)
+	raw := make([]byte, 0, len(l.raw)-len(l.key)+len(key))
+	raw = append(raw, l.raw[:n]...)
+	raw = append(raw, key...)
+	raw = append(raw, l.raw[n+len(l.key):]...)
+
+	l.raw, l.key = raw, key
+	l.prefix = raw[:n]
+	l.tail = raw[len(raw)-len(l.tail):]
+}
+
+// newAssign returns a new assignment line.
+func newAssign(key, value string) *logicalLine {
+	line := &logicalLine{kind: assignLine, key: key, tail: []byte{'\n'}}
+	line.setValue(value)
+	return line
+}
+
+// setValue sets the value of the assignment, and rewrites its text.
+func (l *logicalLine) setValue(value string) {
+	l.value = value
+	l.word = word{{lit: value}}
+	l.format()
+}
+
+// format rewrites the text of the assignment, keeping the text before the
+// key and the comment after the value.
+func (l *logicalLine) format() {
+	b := make([]byte, 0, len(l.prefix)+len(l.key)+len(l.value)+len(l.tail)+3)
+	b = append(b, l.prefix...)
+	b = append(b, l.key...)
+	b = append(b, '=')
+	if l.array != noArray {
+		b = append(b, l.formatArray()...)
+	} else {
+		b = append(b, Quote(l.value)...)
+	}
+	b = append(b, l.tail...)
+	l.raw = b
+}
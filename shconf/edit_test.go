@@ -0,0 +1,153 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const editFile = `# main comment
+
+  # indented comment
+export PATH="/bin:$HOME/bin"   # trailing comment
+Int=2
+
+# Network
+HOST='localhost'
+PORT=80
+MULTI="one
+two"
+LAST=end`
+
+func TestRoundTrip(t *testing.T) {
+	cfg, err := ParseString(editFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if _, err = cfg.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != editFile {
+		t.Errorf("WriteTo got\n%s\nwant\n%s", b.String(), editFile)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	cfg, err := ParseString(editFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cfg.SetValue("PATH", "/usr/bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.SetValue("HOST", "my host"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.AddKey("Float", "3.3", "Int"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.AddKeyUnder("TIMEOUT", "30", "Network"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.AddKey("NEW", "$x", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.DeleteKey("MULTI"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.RenameKey("PORT", "HTTP_PORT"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# main comment
+
+  # indented comment
+export PATH=/usr/bin   # trailing comment
+Int=2
+Float=3.3
+
+# Network
+HOST="my host"
+HTTP_PORT=80
+LAST=end
+TIMEOUT=30
+NEW="\$x"
+`
+	var b bytes.Buffer
+	cfg.WriteTo(&b)
+	if b.String() != want {
+		t.Errorf("WriteTo got\n%s\nwant\n%s", b.String(), want)
+	}
+
+	// The result has to be parsed to the same values.
+	cfg2, err := ParseString(b.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range cfg.data {
+		if cfg2.data[k] != v {
+			t.Errorf("key %s: got %q, want %q", k, cfg2.data[k], v)
+		}
+	}
+
+	if err = cfg.AddKey("Int", "1", ""); err == nil {
+		t.Error("AddKey: expected error for existent key")
+	}
+	if err = cfg.DeleteKey("MULTI"); err == nil {
+		t.Error("DeleteKey: expected error for missing key")
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	const in = `BASE=/opt
+LOG="$BASE/log" # c
+Q='a "b"'
+C=(x "$BASE" 'y z')
+declare -A M=([k]="$BASE")
+`
+	const want = `BASE=/opt
+LOGDIR="$BASE/log" # c
+QUOTED='a "b"'
+ARR=(x "$BASE" 'y z')
+declare -A MAP=([k]="$BASE")
+`
+	for _, mode := range []Mode{0, Expand} {
+		cfg, err := (&Parser{Mode: mode}).Parse(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range [][2]string{{"LOG", "LOGDIR"}, {"Q", "QUOTED"}, {"C", "ARR"}, {"M", "MAP"}} {
+			if err = cfg.RenameKey(k[0], k[1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var b bytes.Buffer
+		cfg.WriteTo(&b)
+		if b.String() != want {
+			t.Errorf("mode %d: WriteTo got\n%s\nwant\n%s", mode, b.String(), want)
+		}
+		if mode == Expand && cfg.String("LOGDIR") != "/opt/log" {
+			t.Errorf("got value %q", cfg.String("LOGDIR"))
+		}
+
+		// The renamed keys can be edited.
+		if err = cfg.SetValue("LOGDIR", "/var/log"); err != nil {
+			t.Fatal(err)
+		}
+		b.Reset()
+		cfg.WriteTo(&b)
+		if !strings.Contains(b.String(), "\nLOGDIR=/var/log # c\n") {
+			t.Errorf("mode %d: got after SetValue\n%s", mode, b.String())
+		}
+	}
+}
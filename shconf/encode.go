@@ -0,0 +1,295 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var textMarshalerType = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
+
+// Marshal returns the configuration file for the struct v, which can be
+// parsed by ParseFile. See Encoder.Encode for the format.
+func Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// An Encoder writes structs as configuration files.
+type Encoder struct {
+	w io.Writer
+
+	// Doc is the comment about the file, written at the beginning. It is
+	// used instead of the comment of the struct, if any.
+	Doc string
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the struct v, or a pointer to it, as a configuration file.
+//
+// Every field is written as "KEY=value" line, where the key is got as in
+// Unmarshal, and the value is quoted for the shell when it is needed. The
+// fields are written in the order of the struct; a field without a value in
+// a pointer, slice, map or interface is skipped. A map is written as an
+// associative array.
+//
+// The "doc" tag of a field is written as a comment above its key, which
+// starts a new group of keys; in a struct field, it is written above the
+// keys of the struct. The comment about the file is got from the "doc" tag
+// of a blank field, when it is the first one of the struct:
+//
+//	type Config struct {
+//		_    struct{} `doc:"Configuration of the server."`
+//		Host string   `shconf:"HOST" doc:"Name or IP address of the server."`
+//		Port int      `shconf:"PORT"`
+//	}
+func (e *Encoder) Encode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errors.New("shconf: Encode needs a struct or a pointer to a struct")
+	}
+
+	enc := new(encodeState)
+	doc := e.Doc
+	if doc == "" && rv.NumField() != 0 {
+		if f := rv.Type().Field(0); f.Name == "_" {
+			doc = f.Tag.Get("doc")
+		}
+	}
+	if doc != "" {
+		writeComment(&enc.b, doc)
+		enc.b.WriteByte('\n')
+	}
+
+	if err := enc.encodeStruct(rv, "", ""); err != nil {
+		return err
+	}
+	_, err := e.w.Write(enc.b.Bytes())
+	return err
+}
+
+// encodeState holds the output of an encoding.
+type encodeState struct {
+	b     bytes.Buffer
+	nKeys int // keys written
+}
+
+// encodeStruct writes the fields of the struct v, with the keys prefixed;
+// path is the name of v, for the errors.
+func (e *encodeState) encodeStruct(v reflect.Value, prefix, path string) error {
+	typ := v.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tagValue, hasTag := field.Tag.Lookup("shconf")
+		if tagValue == "-" {
+			continue
+		}
+		tag, err := parseTag(tagValue)
+		if err != nil {
+			return &FieldError{path + field.Name, prefix + tag.key, err}
+		}
+		fv := v.Field(i)
+
+		if doc := field.Tag.Get("doc"); doc != "" {
+			e.group(doc)
+		}
+
+		if isStruct(field.Type) && !field.Type.Implements(textMarshalerType) {
+			p := tag.key
+			if !hasTag && !field.Anonymous {
+				p = field.Name + "_"
+			}
+			if err := e.encodeStruct(fv, prefix+p, path+field.Name+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		switch fv.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			if fv.IsNil() {
+				continue
+			}
+		}
+
+		key := tag.key
+		if key == "" {
+			key = field.Name
+		}
+		if fv.Kind() == reflect.Slice && tag.array {
+			array, err := formatSlice(fv)
+			if err != nil {
+				return &FieldError{path + field.Name, prefix + key, err}
+			}
+			e.b.WriteString(prefix + key + "=")
+			e.b.Write(array)
+			e.b.WriteByte('\n')
+			e.nKeys++
+			continue
+		}
+		if fv.Kind() == reflect.Map {
+			array, err := formatMap(fv)
+			if err != nil {
+				return &FieldError{path + field.Name, prefix + key, err}
+			}
+			e.b.WriteString("declare -A " + prefix + key + "=")
+			e.b.Write(array)
+			e.b.WriteByte('\n')
+			e.nKeys++
+			continue
+		}
+		value, err := formatValue(fv, tag.sep)
+		if err != nil {
+			return &FieldError{path + field.Name, prefix + key, err}
+		}
+
+		e.b.WriteString(prefix + key)
+		e.b.WriteByte('=')
+		e.b.WriteString(Quote(value))
+		e.b.WriteByte('\n')
+		e.nKeys++
+	}
+	return nil
+}
+
+// group starts a new group of keys with the given comment.
+func (e *encodeState) group(doc string) {
+	if e.nKeys != 0 {
+		e.b.WriteByte('\n')
+	}
+	writeComment(&e.b, doc)
+}
+
+// writeComment writes text as comment lines.
+func writeComment(b *bytes.Buffer, text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if line == "" {
+			b.WriteString("#\n")
+			continue
+		}
+		b.WriteString("# ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+}
+
+// formatSlice returns the text of the slice v as an array.
+func formatSlice(v reflect.Value) ([]byte, error) {
+	line := &logicalLine{array: indexedArray}
+	for i := 0; i < v.Len(); i++ {
+		value, err := formatValue(v.Index(i), "")
+		if err != nil {
+			return nil, err
+		}
+		line.elems = append(line.elems, element{value: value})
+	}
+	return line.formatArray(), nil
+}
+
+// formatMap returns the text of the map v as an associative array, in order
+// of its keys.
+func formatMap(v reflect.Value) ([]byte, error) {
+	if v.Type().Key().Kind() != reflect.String {
+		return nil, errors.New("unsupported type " + v.Type().String())
+	}
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	line := &logicalLine{array: assocArray}
+	for _, k := range keys {
+		value, err := formatValue(v.MapIndex(k), "")
+		if err != nil {
+			return nil, err
+		}
+		line.elems = append(line.elems, element{key: k.String(), value: value})
+	}
+	return line.formatArray(), nil
+}
+
+// formatValue returns the text of the value v; sep is the separator of the
+// elements of a slice. A nil pointer or interface, as element of a slice or a
+// map, has not text.
+func formatValue(v reflect.Value, sep string) (string, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "", errors.New("nil element of type " + v.Type().String())
+		}
+	}
+	if v.Type().Implements(textMarshalerType) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+	}
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Complex64, reflect.Complex128:
+		return strconv.FormatComplex(v.Complex(), 'g', -1, v.Type().Bits()), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Ptr, reflect.Interface:
+		return formatValue(v.Elem(), sep)
+
+	case reflect.Slice:
+		elems := make([]string, v.Len())
+		for i := range elems {
+			s, err := formatValue(v.Index(i), "")
+			if err != nil {
+				return "", err
+			}
+			if sep == "" && (s == "" || strings.ContainsAny(s, ", \t\n")) {
+				return "", errors.New("element " + strconv.Quote(s) +
+					" can not be separated without a separator")
+			}
+			if sep != "" && strings.Contains(s, sep) {
+				return "", errors.New("element " + strconv.Quote(s) +
+					" has the separator " + strconv.Quote(sep))
+			}
+			elems[i] = s
+		}
+		if sep == "" {
+			sep = " "
+		}
+		return strings.Join(elems, sep), nil
+	}
+	return "", errors.New("unsupported type " + v.Type().String())
+}
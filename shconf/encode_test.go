@@ -0,0 +1,96 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type serverConfig struct {
+	_       struct{}      `doc:"Configuration of the server."`
+	Host    string        `shconf:"HOST" doc:"Name or IP address\nof the server."`
+	Port    int           `shconf:"PORT"`
+	Timeout time.Duration `shconf:"TIMEOUT"`
+	Motd    string        `shconf:"MOTD" doc:"Message of the day."`
+	Modules []string      `shconf:"MODULES"`
+	Path    []string      `shconf:"PATH,sep=:"`
+	Skip    *int          `shconf:"SKIP"`
+
+	DB struct {
+		User string `shconf:"USER"`
+		Pass string `shconf:"PASS"`
+	} `shconf:"DB_" doc:"Database."`
+}
+
+func TestMarshal(t *testing.T) {
+	var v serverConfig
+	v.Host = "localhost"
+	v.Port = 8080
+	v.Timeout = 2 * time.Second
+	v.Motd = `Say "hello" for $5`
+	v.Modules = []string{"auth", "log"}
+	v.Path = []string{"/bin", "/usr/local/bin"}
+	v.DB.User = "admin"
+	v.DB.Pass = "it's secret"
+
+	want := `# Configuration of the server.
+
+# Name or IP address
+# of the server.
+HOST=localhost
+PORT=8080
+TIMEOUT=2s
+
+# Message of the day.
+MOTD="Say \"hello\" for \$5"
+MODULES="auth log"
+PATH=/bin:/usr/local/bin
+
+# Database.
+DB_USER=admin
+DB_PASS="it's secret"
+`
+	b, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != want {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+
+	cfg, err := ParseBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got serverConfig
+	if err = Unmarshal(cfg, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("round-trip got %+v, want %+v", got, v)
+	}
+
+	v.Modules = []string{"a b"}
+	if _, err = Marshal(v); err == nil {
+		t.Error("expected error for an element with blanks")
+	}
+
+	// The nil elements have not value.
+	for _, v := range []interface{}{
+		&struct{ A []*int }{A: []*int{nil}},
+		&struct{ A map[string]interface{} }{A: map[string]interface{}{"k": nil}},
+		&struct {
+			A []interface{} `shconf:"A,array"`
+		}{A: []interface{}{nil}},
+	} {
+		if _, err = Marshal(v); err == nil {
+			t.Errorf("%+v: expected error for a nil element", v)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Environ returns the keys and values of the configuration as environment
+// variables, in the form "KEY=value", in the order of Keys.
+func (c *Config) Environ() []string {
+	return c.MergeEnv(nil, nil)
+}
+
+// ApplyEnv sets the environment variables of the process from the keys and
+// values of the configuration.
+func (c *Config) ApplyEnv() error {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, key := range c.keys() {
+		if err := os.Setenv(key, c.data[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeEnv returns the environment env, in the form "KEY=value", with the
+// keys of the configuration for which allow returns true added over it. A nil
+// allow adds all the keys.
+func (c *Config) MergeEnv(env []string, allow func(key string) bool) []string {
+	c.RLock()
+	defer c.RUnlock()
+
+	keys := c.keys()
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = allow == nil || allow(key)
+	}
+
+	merged := make([]string, 0, len(env)+len(keys))
+	for _, kv := range env {
+		key := kv
+		if i := strings.IndexByte(kv, '='); i != -1 {
+			key = kv[:i]
+		}
+		if !set[key] {
+			merged = append(merged, kv)
+		}
+	}
+	for _, key := range keys {
+		if set[key] {
+			merged = append(merged, key+"="+c.data[key])
+		}
+	}
+	return merged
+}
+
+// Command returns the exec.Cmd to run the program name with the arguments
+// arg, such as exec.Command does, with the environment of the process and
+// the keys of the configuration for which allow returns true. A nil allow
+// adds all the keys.
+func (c *Config) Command(allow func(key string) bool, name string, arg ...string) *exec.Cmd {
+	cmd := exec.Command(name, arg...)
+	cmd.Env = c.MergeEnv(os.Environ(), allow)
+	return cmd
+}
+
+// AllowKeys returns a function for MergeEnv and Command which allows the
+// given keys.
+func AllowKeys(keys ...string) func(key string) bool {
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return func(key string) bool { return set[key] }
+}
+
+// AllowPrefix returns a function for MergeEnv and Command which allows the
+// keys starting with prefix.
+func AllowPrefix(prefix string) func(key string) bool {
+	return func(key string) bool { return strings.HasPrefix(key, prefix) }
+}
+
+// FromEnv creates a new Config from the environment variables of the process
+// whose names start with prefix; the keys are named without the prefix.
+func FromEnv(prefix string) *Config {
+	return ParseEnv(os.Environ(), prefix)
+}
+
+// ParseEnv creates a new Config from the environment env, in the form
+// "KEY=value", with the variables whose names start with prefix; the keys are
+// named without the prefix, and sorted. The variables whose names are not
+// valid keys are skipped.
+func ParseEnv(env []string, prefix string) *Config {
+	cfg := &Config{
+		data: make(map[string]string),
+	}
+
+	for _, kv := range env {
+		i := strings.IndexByte(kv, '=')
+		if i == -1 || !strings.HasPrefix(kv[:i], prefix) {
+			continue
+		}
+		key := kv[len(prefix):i]
+		if !isName(key) {
+			continue
+		}
+		// The first value wins, such as in os.Getenv.
+		if _, found := cfg.data[key]; !found {
+			cfg.data[key] = kv[i+1:]
+		}
+	}
+
+	keys := make([]string, 0, len(cfg.data))
+	for key := range cfg.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cfg.lines = append(cfg.lines, newAssign(key, cfg.data[key]))
+	}
+	cfg.update()
+	return cfg
+}
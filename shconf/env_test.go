@@ -0,0 +1,83 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestEnviron(t *testing.T) {
+	cfg, err := ParseString("APP_HOST=localhost\nAPP_PORT=80\nDEBUG='yes please'\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"APP_HOST=localhost", "APP_PORT=80", "DEBUG=yes please"}
+	if env := cfg.Environ(); !reflect.DeepEqual(env, want) {
+		t.Errorf("Environ got %q, want %q", env, want)
+	}
+
+	env := []string{"PATH=/bin", "APP_PORT=8080", "DEBUG=no", "EMPTY"}
+	for _, tt := range []struct {
+		allow func(string) bool
+		want  []string
+	}{
+		{nil, []string{"PATH=/bin", "EMPTY", "APP_HOST=localhost", "APP_PORT=80", "DEBUG=yes please"}},
+		{AllowPrefix("APP_"), []string{"PATH=/bin", "DEBUG=no", "EMPTY", "APP_HOST=localhost", "APP_PORT=80"}},
+		{AllowKeys("DEBUG"), []string{"PATH=/bin", "APP_PORT=8080", "EMPTY", "DEBUG=yes please"}},
+	} {
+		if got := cfg.MergeEnv(env, tt.allow); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MergeEnv got %q, want %q", got, tt.want)
+		}
+	}
+
+	if err = cfg.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, key := range cfg.Keys() {
+			os.Unsetenv(key)
+		}
+	}()
+	if v := os.Getenv("DEBUG"); v != "yes please" {
+		t.Errorf("DEBUG got %q after ApplyEnv", v)
+	}
+
+	if runtime.GOOS != "windows" {
+		var out bytes.Buffer
+		cmd := cfg.Command(AllowKeys("APP_PORT"), "sh", "-c", "echo $APP_PORT $PATH")
+		cmd.Stdout = &out
+		if err = cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(out.String()); got != "80 "+os.Getenv("PATH") {
+			t.Errorf("Command got output %q", got)
+		}
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	cfg := ParseEnv([]string{
+		"APP_PORT=80", "PATH=/bin", "APP_HOST=a=b", "APP_=x", "APP_PORT=90", "APP_1X=y",
+	}, "APP_")
+
+	want := map[string]string{"HOST": "a=b", "PORT": "80"}
+	if !reflect.DeepEqual(cfg.data, want) {
+		t.Errorf("data got %v, want %v", cfg.data, want)
+	}
+
+	var b bytes.Buffer
+	cfg.WriteTo(&b)
+	if got := b.String(); got != "HOST=a=b\nPORT=80\n" {
+		t.Errorf("WriteTo got %q", got)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// A ParseError is returned when the configuration has a syntax error, or a
+// value can not be expanded.
+type ParseError struct {
+	Filename string // empty if the configuration is not backed by a file
+	Line     int
+	Column   int // byte column, starting at 1
+	Reason   string
+}
+
+func (e *ParseError) Error() string {
+	var b bytes.Buffer
+
+	// The line is zero in an error about the whole configuration.
+	switch {
+	case e.Line == 0:
+		if e.Filename != "" {
+			b.WriteString(e.Filename)
+			b.WriteString(": ")
+		}
+	case e.Filename != "":
+		b.WriteString(e.Filename)
+		b.WriteByte(':')
+	default:
+		b.WriteString("line ")
+	}
+	if e.Line > 0 {
+		b.WriteString(strconv.Itoa(e.Line))
+		if e.Column > 0 {
+			b.WriteByte(':')
+			b.WriteString(strconv.Itoa(e.Column))
+		}
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Reason)
+	return b.String()
+}
+
+// An ErrorList is a list of parse errors, sorted by position. It is returned
+// when the configuration is parsed in Strict mode.
+type ErrorList []*ParseError
+
+func (l ErrorList) Len() int      { return len(l) }
+func (l ErrorList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+func (l ErrorList) Less(i, j int) bool {
+	if l[i].Filename != l[j].Filename {
+		return l[i].Filename < l[j].Filename
+	}
+	if l[i].Line != l[j].Line {
+		return l[i].Line < l[j].Line
+	}
+	return l[i].Column < l[j].Column
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// err returns an error equivalent to this list, or nil if it is empty.
+func (l ErrorList) err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	sort.Stable(l)
+	return l
+}
+
+// A lexError is an error found at lexing a logical line.
+type lexError struct {
+	rest       int // length of the text from the error to the end of the line
+	reason     string
+	incomplete bool // whether the line goes on in the next physical line
+}
+
+func (e *lexError) Error() string { return e.reason }
+
+// newLexError returns a lexError at the beginning of src, which is the text
+// not yet read from a logical line.
+func newLexError(src []byte, reason string) *lexError {
+	return &lexError{rest: len(src), reason: reason}
+}
+
+// incompleteError returns a lexError for text opened at the beginning of src,
+// which is not closed in the logical line.
+func incompleteError(src []byte, reason string) *lexError {
+	return &lexError{rest: len(src), reason: reason, incomplete: true}
+}
+
+// isIncomplete reports whether err indicates that the logical line goes on.
+func isIncomplete(err error) bool {
+	e, ok := err.(*lexError)
+	return ok && e.incomplete
+}
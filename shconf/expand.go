@@ -0,0 +1,240 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// An expander expands the parameters in the values of the assignments of a
+// configuration.
+//
+// A reference to a key is resolved to its definition above the line where it
+// is used, such as a shell does; else it is looked up in the environment, if
+// lookupEnv is set, and else it is unset. The lines are expanded in order, so
+// the definitions above are already expanded.
+//
+// A parameter "${NAME=word}" or "${NAME:=word}" assigns the word to NAME when
+// it is unset, or null with ':', such as a shell does; the assignment is seen
+// by the references to NAME after of it, in the same line or below.
+type expander struct {
+	lines     []*logicalLine // assignments
+	defs      map[string][]int
+	sites     map[string][]int // lines with parameters which assign to a name
+	lookupEnv func(string) (string, bool)
+
+	assigned map[int]map[string]string // line: name: value assigned in the line
+}
+
+// expand expands the values of the given assignments, in order. If errs is
+// not nil, the errors are added to it instead of stopping at the first one.
+func expand(lines []*logicalLine, lookupEnv func(string) (string, bool), errs *ErrorList) error {
+	x := &expander{
+		lines:     lines,
+		defs:      make(map[string][]int),
+		sites:     make(map[string][]int),
+		lookupEnv: lookupEnv,
+		assigned:  make(map[int]map[string]string),
+	}
+	for i, l := range lines {
+		x.defs[l.key] = append(x.defs[l.key], i)
+
+		x.addSites(i, l.word)
+		for _, e := range l.elems {
+			x.addSites(i, e.word)
+		}
+	}
+
+	for i := range lines {
+		if err := x.line(i); err != nil {
+			if errs == nil {
+				return err
+			}
+			*errs = append(*errs, err)
+		}
+	}
+	return nil
+}
+
+// line expands the value of the assignment at index i.
+func (x *expander) line(i int) *ParseError {
+	l := x.lines[i]
+	if l.array == noArray {
+		value, err := x.word(i, l.word)
+		l.value = value
+		return err
+	}
+
+	for j := range l.elems {
+		value, err := x.word(i, l.elems[j].word)
+		if err != nil {
+			return err
+		}
+		l.elems[j].value = value
+	}
+	l.value = l.joinElems()
+	return nil
+}
+
+// word returns the expansion of w, used in the line at index i.
+func (x *expander) word(i int, w word) (string, *ParseError) {
+	if len(w) == 1 && w[0].p == nil {
+		return w[0].lit, nil
+	}
+
+	var b bytes.Buffer
+	for _, s := range w {
+		if s.p == nil {
+			b.WriteString(s.lit)
+			continue
+		}
+		val, err := x.param(i, s.p)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(val)
+	}
+	return b.String(), nil
+}
+
+// param returns the expansion of p, used in the line at index i.
+func (x *expander) param(i int, p *param) (string, *ParseError) {
+	switch {
+	case p.name == "(":
+		return "", x.errorf(i, p.rest, "command substitution is not supported: %s", p.raw)
+	case !isName(p.name):
+		return "", x.errorf(i, p.rest, "special parameter is not supported: %s", p.raw)
+	}
+
+	val, set := x.lookup(i, p.name)
+	if p.op == "" {
+		return val, nil
+	}
+
+	// With ':', a null value is handled as if it were unset.
+	if p.op[0] == ':' && val == "" {
+		set = false
+	}
+
+	switch p.op[len(p.op)-1] {
+	case '-':
+		if !set {
+			return x.word(i, p.arg)
+		}
+	case '=':
+		if !set {
+			val, err := x.word(i, p.arg)
+			if err != nil {
+				return "", err
+			}
+			if x.assigned[i] == nil {
+				x.assigned[i] = make(map[string]string)
+			}
+			x.assigned[i][p.name] = val
+			return val, nil
+		}
+	case '+':
+		if set {
+			return x.word(i, p.arg)
+		}
+		return "", nil
+	case '?':
+		if !set {
+			msg, err := x.word(i, p.arg)
+			if err != nil {
+				return "", err
+			}
+			if msg == "" {
+				msg = "parameter null or not set"
+			}
+			return "", x.errorf(i, p.rest, "%s: %s", p.name, msg)
+		}
+	}
+	return val, nil
+}
+
+// lookup returns the value of the key name used in the line at index i, and
+// whether it is set.
+func (x *expander) lookup(i int, name string) (value string, set bool) {
+	defs := x.defs[name]
+
+	// Assignment in the same line, before of the reference.
+	if value, set = x.assigned[i][name]; set {
+		return value, true
+	}
+
+	// Definition or assignment above; the nearest one wins.
+	sites := x.sites[name]
+	d, s := len(defs)-1, len(sites)-1
+	for d >= 0 && defs[d] >= i {
+		d--
+	}
+	for s >= 0 && sites[s] >= i {
+		s--
+	}
+	for d >= 0 || s >= 0 {
+		if s >= 0 && (d < 0 || sites[s] > defs[d]) {
+			// The parameter assigns only if the name is unset there.
+			if value, set = x.assigned[sites[s]][name]; set {
+				return value, true
+			}
+			s--
+			continue
+		}
+		return x.lines[defs[d]].value, true
+	}
+
+	if x.lookupEnv != nil {
+		if value, set = x.lookupEnv(name); set {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// addSites adds the line at index i to the lines with parameters which assign
+// to a name, for every one of them in w.
+func (x *expander) addSites(i int, w word) {
+	for _, s := range w {
+		if s.p == nil {
+			continue
+		}
+		if op := s.p.op; op != "" && op[len(op)-1] == '=' {
+			if sites := x.sites[s.p.name]; len(sites) == 0 || sites[len(sites)-1] != i {
+				x.sites[s.p.name] = append(sites, i)
+			}
+		}
+		x.addSites(i, s.p.arg)
+	}
+}
+
+// errorf returns a ParseError for the line at index i, at the position given
+// by the length of the text from there to the end of the line; when rest is
+// zero the column is not set.
+func (x *expander) errorf(i, rest int, format string, args ...interface{}) *ParseError {
+	l := x.lines[i]
+	reason := "key " + l.key + ": " + fmt.Sprintf(format, args...)
+
+	if rest == 0 {
+		return &ParseError{Filename: l.file, Line: l.line, Reason: reason}
+	}
+	return l.error(l.file, rest, reason)
+}
+
+// isName reports whether s is a valid variable name.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,218 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Several processes can edit the same file. The file is locked with an
+// advisory lock while it is written, and it is not written if it has been
+// changed since it was read, unless the changes can be merged.
+
+// A ConflictMode indicates what is done at writing when the file has been
+// changed by other process since it was read.
+type ConflictMode int
+
+const (
+	FailOnConflict  ConflictMode = iota // return a *ConflictError
+	MergeOnConflict                     // merge the keys edited in both sides
+)
+
+// SetConflict sets what is done when the file has been changed by other
+// process.
+func (c *Config) SetConflict(mode ConflictMode) {
+	c.Lock()
+	c.conflict = mode
+	c.Unlock()
+}
+
+// ConflictError is returned at writing when the file has been changed since
+// it was read. Keys are the keys which could not be merged because they have
+// been edited in both sides.
+type ConflictError struct {
+	Filename string
+	Keys     []string
+}
+
+func (e *ConflictError) Error() string {
+	s := "file changed since it was read: " + e.Filename
+	if len(e.Keys) != 0 {
+		s += "; keys edited in both sides: " + strings.Join(e.Keys, ", ")
+	}
+	return s
+}
+
+// A fileStamp identifies the content of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+	read    time.Time // when the file was read
+}
+
+// changed reports whether the named file is different to the stamp. The
+// content is only compared when the size and the modification time are the
+// same, and the file could have been written again in the same tick of the
+// clock of the filesystem.
+func (s *fileStamp) changed(name string) (bool, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != s.size {
+		return true, nil
+	}
+	if info.ModTime().Equal(s.modTime) && s.read.Sub(s.modTime) > time.Second {
+		return false, nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return false, err
+	}
+	return !bytes.Equal(h.Sum(nil), s.sum[:]), nil
+}
+
+// newStamp returns the stamp of the named file, with the sum of its content.
+func newStamp(name string, sum []byte) (fileStamp, error) {
+	s := fileStamp{read: time.Now()}
+	info, err := os.Stat(name)
+	if err != nil {
+		return s, err
+	}
+	s.modTime, s.size = info.ModTime(), info.Size()
+	copy(s.sum[:], sum)
+	return s, nil
+}
+
+// lineSums returns the sums of the text of the assignments, by key.
+func lineSums(lines []*logicalLine) map[string]uint64 {
+	n := 0
+	for _, line := range lines {
+		if line.kind == assignLine {
+			n++
+		}
+	}
+	sums := make(map[string]uint64, n)
+	for _, line := range lines {
+		if line.kind == assignLine {
+			sums[line.key] = sum64(line.raw)
+		}
+	}
+	return sums
+}
+
+// sum64 returns the FNV-1a hash of b.
+func sum64(b []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// merge merges the keys edited since the file was read into the lines of the
+// file on disk, which are set in the configuration. A key edited in both
+// sides is a conflict, unless it has the same text.
+func (c *Config) merge() error {
+	disk, err := c.parser.ParseFile(c.filename)
+	if err != nil {
+		return err
+	}
+	diskSums := lineSums(disk.lines)
+	ourSums := lineSums(c.lines)
+
+	// The keys edited in this side, in the order of the lines, and the keys
+	// removed.
+	var keys, removed []string
+	for _, line := range c.lines {
+		if line.kind == assignLine {
+			if sum, found := c.orig[line.key]; !found || sum != ourSums[line.key] {
+				keys = append(keys, line.key)
+			}
+		}
+	}
+	for key := range c.orig {
+		if _, found := ourSums[key]; !found {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	keys = append(keys, removed...)
+
+	var conflicts []string
+	for _, key := range keys {
+		orig, inOrig := c.orig[key]
+		ours, inOurs := ourSums[key]
+		theirs, inDisk := diskSums[key]
+
+		switch {
+		case inDisk == inOurs && theirs == ours:
+			continue // the same edition
+		case inDisk != inOrig || theirs != orig:
+			conflicts = append(conflicts, key)
+			continue
+		}
+
+		i := disk.index(key)
+		switch {
+		case !inOurs:
+			disk.lines = append(disk.lines[:i], disk.lines[i+1:]...)
+		case inDisk:
+			disk.lines[i] = withNewline(c.find(key), i+1 < len(disk.lines))
+		default:
+			// After of the key which is above in this side.
+			j := len(disk.lines)
+			for k := c.index(key) - 1; k >= 0; k-- {
+				if prev := c.lines[k]; prev.kind == assignLine {
+					if n := disk.index(prev.key); n != -1 {
+						j = n + 1
+						break
+					}
+				}
+			}
+			disk.insert(j, withNewline(c.find(key), j < len(disk.lines)))
+		}
+	}
+	if len(conflicts) != 0 {
+		return &ConflictError{c.filename, conflicts}
+	}
+
+	var b bytes.Buffer
+	disk.writeTo(&b)
+	cfg, err := c.parser.parse(&b, c.filename)
+	if err != nil {
+		return err
+	}
+	c.data, c.lines, c.comment = cfg.data, cfg.lines, cfg.comment
+	c.externals, c.secret = cfg.externals, cfg.secret
+	return nil
+}
+
+// withNewline returns a copy of line, ended in a newline if newline is true.
+func withNewline(line *logicalLine, newline bool) *logicalLine {
+	l := *line
+	if newline && !bytes.HasSuffix(l.raw, []byte{'\n'}) {
+		l.raw = append(l.raw[:len(l.raw):len(l.raw)], '\n')
+		l.tail = append(l.tail[:len(l.tail):len(l.tail)], '\n')
+	}
+	return &l
+}
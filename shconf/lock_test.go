@@ -0,0 +1,159 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConflict(t *testing.T) {
+	name := tempFile(t, "A=1\nB=2")
+
+	parse := func(mode ConflictMode) *Config {
+		cfg, err := ParseFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.SetConflict(mode)
+		return cfg
+	}
+	checkFile := func(want string) {
+		got, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("file got %q, want %q", got, want)
+		}
+	}
+
+	cfg1 := parse(FailOnConflict)
+	cfg2 := parse(FailOnConflict)
+	if err := cfg1.WriteValue("A", "10"); err != nil {
+		t.Fatal(err)
+	}
+	err := cfg2.WriteValue("B", "20")
+	if e, ok := err.(*ConflictError); !ok || e.Filename != name || len(e.Keys) != 0 {
+		t.Errorf("WriteValue got error %v, want *ConflictError", err)
+	}
+	if got := cfg2.String("B"); got != "2" {
+		t.Errorf("value after of conflict got %q, want %q", got, "2")
+	}
+	checkFile("A=10\nB=2\n")
+
+	// The changes of both sides are merged.
+	cfg2.SetConflict(MergeOnConflict)
+	if err = cfg2.WriteValue("B", "20"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile("A=10\nB=20\n")
+	if got := cfg2.String("A"); got != "10" {
+		t.Errorf("value after of merge got %q, want %q", got, "10")
+	}
+
+	cfg1 = parse(FailOnConflict)
+	cfg2 = parse(MergeOnConflict)
+	if err = cfg1.AddKey("C", "3", "A"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg1.Save(); err != nil {
+		t.Fatal(err)
+	}
+	cfg2.DeleteKey("B")
+	cfg2.AddKey("D", "4", "")
+	cfg2.AddKey("E", "5", "A")
+	if err = cfg2.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// The keys added are placed after of the key above them.
+	checkFile("A=10\nE=5\nD=4\nC=3\n")
+	if got, want := cfg2.Keys(), []string{"A", "E", "D", "C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys after of merge got %q, want %q", got, want)
+	}
+
+	// The same key edited in both sides.
+	cfg1 = parse(FailOnConflict)
+	cfg2 = parse(MergeOnConflict)
+	cfg1.WriteValue("A", "x")
+	cfg1.WriteValue("C", "y")
+	cfg2.SetValue("D", "z")
+	err = cfg2.WriteValue("A", "x")
+	if err != nil {
+		t.Errorf("WriteValue with the same value got error: %s", err)
+	}
+	checkFile("A=x\nE=5\nD=z\nC=y\n")
+
+	cfg1 = parse(FailOnConflict)
+	cfg2 = parse(MergeOnConflict)
+	cfg1.WriteValue("A", "1")
+	cfg1.WriteValue("C", "w")
+	cfg2.DeleteKey("E")
+	cfg2.DeleteKey("C")
+	err = cfg2.WriteValue("A", "2")
+	if e, ok := err.(*ConflictError); !ok || !reflect.DeepEqual(e.Keys, []string{"A", "C"}) {
+		t.Errorf("WriteValue got error %v, want *ConflictError in keys A, C", err)
+	}
+	checkFile("A=1\nE=5\nD=z\nC=w\n")
+
+	// The file is touched but not changed.
+	cfg1 = parse(FailOnConflict)
+	future := time.Now().Add(time.Hour)
+	if err = os.Chtimes(name, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg1.WriteValue("A", "3"); err != nil {
+		t.Errorf("WriteValue after of touching the file got error: %s", err)
+	}
+}
+
+func TestConcurrentWrite(t *testing.T) {
+	name := tempFile(t, "A=1")
+
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cfg, err := ParseFile(name)
+			if err != nil {
+				errs <- err
+				return
+			}
+			cfg.SetConflict(MergeOnConflict)
+			if err = cfg.AddKey("K"+strconv.Itoa(i), strconv.Itoa(i), ""); err == nil {
+				err = cfg.Save()
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := ParseFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if key := "K" + strconv.Itoa(i); cfg.String(key) != strconv.Itoa(i) {
+			t.Errorf("key %s was lost", key)
+		}
+	}
+}
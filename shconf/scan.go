@@ -0,0 +1,738 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// A lineKind indicates the type of a logical line.
+type lineKind int
+
+const (
+	blankLine lineKind = iota
+	commentLine
+	assignLine
+	sourceLine // ". FILE" or "source FILE", with the name of the file in value
+)
+
+// A logicalLine is a line of the configuration such as the shell sees it; it
+// can span several physical lines when a quoted value holds newlines or when
+// a line is continued with a backslash.
+type logicalLine struct {
+	kind  lineKind
+	file  string // name of the file where the line is
+	raw   []byte // text as it is in the file, with the line ending
+	line  int    // number of the first physical line
+	off   int64  // offset of the first byte
+	key   string
+	value string
+	word  word // value before the expansion
+
+	export  bool   // whether the assignment starts with "export"
+	comment string // comment text, without the "#"
+
+	secret bool // whether the value is secret
+
+	array arrayKind // kind of array, for an array assignment
+	elems []element // elements of an array
+
+	// Text before the key, and after the value, for rewriting the line.
+	prefix, tail []byte
+}
+
+// An Entry is a key read by a Scanner.
+type Entry struct {
+	Key     string
+	Value   string // unquoted, without expanding the references
+	Comment string // comment lines just above the key, and comment after its value
+	Line    int    // number of the line where the key is
+}
+
+// A Scanner reads the keys of a configuration one by one, without building a
+// Config, so it can read big files using little memory. The lines which
+// include other files are skipped, and the duplicated keys are not checked.
+type Scanner struct {
+	r        *bufio.Reader
+	filename string // used in the errors
+	line     int    // number of physical lines read
+	off      int64  // offset of the next byte to read
+
+	entry Entry
+	doc   []string // comment lines above the next key
+	err   error
+
+	// The lines are kept when they are read to build a Config; their memory
+	// is allocated in blocks. Else, the memory of a line is reused.
+	keep  bool
+	cur   logicalLine
+	buf   []byte
+	lines []logicalLine
+}
+
+// NewScanner returns a new Scanner to read from r.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{r: bufio.NewReader(r)}
+}
+
+// Scan advances the Scanner to the next key, which will then be available
+// through the Entry method. It returns false when the scan stops, either by
+// reaching the end of the input or an error.
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	for {
+		l, err := s.next()
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
+			return false
+		}
+
+		switch l.kind {
+		case blankLine:
+			s.doc = s.doc[:0]
+			continue
+		case commentLine:
+			s.doc = append(s.doc, strings.TrimSpace(l.comment))
+			continue
+		case sourceLine:
+			continue
+		}
+
+		if l.comment != "" {
+			s.doc = append(s.doc, l.comment)
+		}
+		s.entry = Entry{l.key, l.value, strings.Join(s.doc, "\n"), l.line}
+		s.doc = s.doc[:0]
+		return true
+	}
+}
+
+// Entry returns the last key read by Scan.
+func (s *Scanner) Entry() Entry { return s.entry }
+
+// Err returns the first error found by the Scanner, which is a *ParseError
+// for a malformed line.
+func (s *Scanner) Err() error { return s.err }
+
+// readLine appends to b a physical line, with its line ending.
+func (s *Scanner) readLine(b []byte) ([]byte, error) {
+	n := len(b)
+	for {
+		line, err := s.r.ReadSlice('\n')
+		b = append(b, line...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if len(b) != n {
+			s.line++
+			s.off += int64(len(b) - n)
+			return b, nil
+		}
+		return b, err
+	}
+}
+
+// next returns the next logical line. It returns io.EOF when there is no more
+// input, and a *ParseError together with the line when it is malformed.
+func (s *Scanner) next() (*logicalLine, error) {
+	off := s.off
+	raw, err := s.readLine(s.buf[:0])
+	if err != nil {
+		return nil, err
+	}
+	l := s.newLine()
+	l.line, l.off = s.line, off
+
+	for {
+		err = l.parse(raw)
+		if !isIncomplete(err) {
+			break
+		}
+		more, e := s.readLine(raw)
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, e
+		}
+		raw = more
+	}
+	l.raw = s.alloc(raw)
+
+	if e, ok := err.(*lexError); ok {
+		return l, l.error(s.filename, e.rest, e.reason)
+	}
+	return l, err
+}
+
+// newLine returns a new logical line.
+func (s *Scanner) newLine() *logicalLine {
+	if !s.keep {
+		s.cur = logicalLine{}
+		return &s.cur
+	}
+	if len(s.lines) == 0 {
+		s.lines = make([]logicalLine, 128)
+	}
+	l := &s.lines[0]
+	s.lines = s.lines[1:]
+	return l
+}
+
+// alloc returns the text of a line, which has been read in the free space of
+// the buffer. The text is left in the buffer when the lines are kept, else the
+// space is reused for the next line.
+func (s *Scanner) alloc(raw []byte) []byte {
+	if !s.keep {
+		s.buf = raw[:0]
+	} else if cap(s.buf) != 0 && &s.buf[:1][0] == &raw[0] {
+		s.buf = s.buf[len(raw):len(raw)]
+	} else {
+		// The line did not fit in the buffer.
+		s.buf = make([]byte, 0, 16<<10)
+	}
+	// The lines can not be extended over the next ones.
+	return raw[:len(raw):len(raw)]
+}
+
+// error returns a ParseError at the position of the text of l whose length
+// from the position to the end is rest.
+func (l *logicalLine) error(filename string, rest int, reason string) *ParseError {
+	off := len(l.raw) - rest
+	if off < 0 || off > len(l.raw) {
+		off = 0
+	}
+	text := l.raw[:off]
+
+	return &ParseError{
+		Filename: filename,
+		Line:     l.line + bytes.Count(text, []byte{'\n'}),
+		Column:   off - bytes.LastIndexByte(text, '\n'),
+		Reason:   reason,
+	}
+}
+
+// parse sets the fields of l from its text.
+func (l *logicalLine) parse(raw []byte) error {
+	text := bytes.TrimLeft(raw, " \t")
+	l.array, l.elems = noArray, nil
+
+	if isEOL(text) {
+		l.kind = blankLine
+		return nil
+	}
+	if text[0] == '#' {
+		l.kind = commentLine
+		l.comment = string(trimComment(text))
+		return nil
+	}
+	if n := sourcePrefix(text); n != 0 {
+		return l.parseSource(raw, text[n:])
+	}
+	l.kind = assignLine
+
+	if bytes.HasPrefix(text, []byte("export")) && len(text) > 6 &&
+		(text[6] == ' ' || text[6] == '\t') {
+		l.export = true
+		text = bytes.TrimLeft(text[6:], " \t")
+	}
+	if n, kind := declarePrefix(text); n != 0 {
+		l.array = kind
+		text = text[n:]
+	}
+
+	i := 0
+	for i < len(text) && isNameChar(text[i], false) {
+		i++
+	}
+	if i == len(text) || text[i] != '=' {
+		if i == 0 {
+			return newLexError(text, "invalid line")
+		}
+		return newLexError(text[i:], "missing '=' after key "+string(text[:i]))
+	}
+	if !isNameChar(text[0], true) {
+		return newLexError(text, "invalid key name "+string(text[:i]))
+	}
+	l.key = string(text[:i])
+	l.prefix = raw[:len(raw)-len(text)]
+
+	var rest []byte
+	var err error
+	if value := text[i+1:]; len(value) != 0 && value[0] == '(' {
+		if l.array == noArray {
+			l.array = indexedArray
+		}
+		if rest, err = l.parseArray(value[1:]); err != nil {
+			return err
+		}
+	} else {
+		if l.array != noArray {
+			return newLexError(value, "missing array after declare")
+		}
+		w, r, err := lexWord(value, 0)
+		if err != nil {
+			return err
+		}
+		l.word, l.value = w, w.String()
+		rest = r
+	}
+	l.tail = rest
+
+	rest = bytes.TrimLeft(rest, " \t")
+	switch {
+	case isEOL(rest):
+	case rest[0] == '#':
+		l.comment = string(trimComment(rest))
+	default:
+		return newLexError(rest, "unexpected text after value: "+
+			string(bytes.TrimRight(rest, "\r\n")))
+	}
+	return nil
+}
+
+// sourcePrefix returns the length of the command "." or "source" at the
+// beginning of text, with the blanks after it, or zero if there is none.
+func sourcePrefix(text []byte) int {
+	n := 0
+	switch {
+	case text[0] == '.':
+		n = 1
+	case bytes.HasPrefix(text, []byte("source")):
+		n = 6
+	}
+	if n == 0 || n == len(text) || (text[n] != ' ' && text[n] != '\t') {
+		return 0
+	}
+	return len(text) - len(bytes.TrimLeft(text[n:], " \t"))
+}
+
+// parseSource sets the fields of the line which includes a file, where text
+// is the name of the file.
+func (l *logicalLine) parseSource(raw, text []byte) error {
+	l.kind = sourceLine
+	l.prefix = raw[:len(raw)-len(text)]
+
+	w, rest, err := lexWord(text, 0)
+	if err != nil {
+		return err
+	}
+	for _, s := range w {
+		if s.p != nil {
+			return newLexError(text, "expansion in name of file is not supported: "+w.String())
+		}
+	}
+	if w.String() == "" {
+		return newLexError(text, "missing name of file")
+	}
+	l.word, l.value = w, w.String()
+	l.tail = rest
+
+	rest = bytes.TrimLeft(rest, " \t")
+	switch {
+	case isEOL(rest):
+	case rest[0] == '#':
+		l.comment = string(trimComment(rest))
+	default:
+		return newLexError(rest, "unexpected text after name of file: "+
+			string(bytes.TrimRight(rest, "\r\n")))
+	}
+	return nil
+}
+
+// A word is the text of a value, split in literal text and in parameter
+// expansions.
+type word []segment
+
+// A segment is a piece of a word; it is literal text when p is nil.
+type segment struct {
+	lit string
+	p   *param
+}
+
+// A param is a parameter expansion, such as "$A" or "${A:-default}", or a
+// command substitution, with name set to "(".
+type param struct {
+	name string
+	op   string // "", "-", ":-", "=", ":=", "?", ":?", "+" or ":+"
+	arg  word   // word after the operator
+	raw  string // text such as it is in the file
+	rest int    // length of the line from the parameter to the end
+}
+
+// String returns the text of the word without expanding the parameters.
+func (w word) String() string {
+	if len(w) == 1 && w[0].p == nil {
+		return w[0].lit
+	}
+	var b bytes.Buffer
+	for _, s := range w {
+		if s.p != nil {
+			b.WriteString(s.p.raw)
+		} else {
+			b.WriteString(s.lit)
+		}
+	}
+	return b.String()
+}
+
+// A wordBuilder builds a word.
+type wordBuilder struct {
+	w   word
+	lit []byte
+}
+
+func (b *wordBuilder) addParam(p *param) {
+	if len(b.lit) != 0 {
+		b.w = append(b.w, segment{lit: string(b.lit)})
+		b.lit = b.lit[:0]
+	}
+	b.w = append(b.w, segment{p: p})
+}
+
+func (b *wordBuilder) word() word {
+	if len(b.lit) != 0 || len(b.w) == 0 {
+		b.w = append(b.w, segment{lit: string(b.lit)})
+	}
+	return b.w
+}
+
+// lexWord unquotes the shell word at the beginning of src, such as it is
+// done by a POSIX shell, and returns the rest of the line after the word.
+//
+// Text inside single quotes is taken literally. Inside double quotes the
+// backslash only escapes '$', '`', '"', '\' and the newline. Out of quotes,
+// the backslash escapes any character, and a backslash before a newline
+// joins both lines.
+//
+// When stop is not zero, the word finishes at that character, which is
+// consumed, instead of at a blank; it is used to lex the argument of a
+// parameter expansion.
+func lexWord(src []byte, stop byte) (w word, rest []byte, err error) {
+	return lexWordIn(src, stop, false)
+}
+
+// lexWordIn is like lexWord, but the word finishes at ')', which is not
+// consumed, if it is an element of an array.
+func lexWordIn(src []byte, stop byte, inArray bool) (w word, rest []byte, err error) {
+	if stop == 0 {
+		if w, n := lexLiteral(src, inArray); n != -1 {
+			return w, src[n:], nil
+		}
+	}
+	// A single allocation for the text of the usual values.
+	size := len(src)
+	if size > 128 {
+		size = 128
+	}
+	b := wordBuilder{lit: make([]byte, 0, size)}
+	i := 0
+
+	for i < len(src) {
+		c := src[i]
+
+		if stop != 0 {
+			if c == stop {
+				return b.word(), src[i+1:], nil
+			}
+			if c != '\\' && c != '\'' && c != '"' && c != '$' && c != '`' {
+				b.lit = append(b.lit, c)
+				i++
+				continue
+			}
+		}
+
+		switch c {
+		case ' ', '\t', '\n':
+			return b.word(), src[i:], nil
+		case '\r':
+			if i+1 < len(src) && src[i+1] == '\n' {
+				return b.word(), src[i:], nil
+			}
+			b.lit = append(b.lit, c)
+			i++
+
+		case ')':
+			if inArray {
+				return b.word(), src[i:], nil
+			}
+			return nil, nil, newLexError(src[i:], "unexpected character ) in value")
+		case ';', '&', '|', '<', '>', '(':
+			return nil, nil, newLexError(src[i:], "unexpected character "+string(c)+" in value")
+
+		case '\\':
+			if i+1 == len(src) {
+				b.lit = append(b.lit, c)
+				i++
+				break
+			}
+			if src[i+1] == '\n' {
+				if i+2 == len(src) {
+					return nil, nil, incompleteError(src[i:], "end of file after backslash")
+				}
+			} else {
+				b.lit = append(b.lit, src[i+1])
+			}
+			i += 2
+
+		case '\'':
+			end := bytes.IndexByte(src[i+1:], '\'')
+			if end == -1 {
+				return nil, nil, incompleteError(src[i:], "unterminated single quote")
+			}
+			b.lit = append(b.lit, src[i+1:i+1+end]...)
+			i += end + 2
+
+		case '"':
+			n, err := lexDQuote(&b, src[i:])
+			if err != nil {
+				return nil, nil, err
+			}
+			i += n
+
+		case '$', '`':
+			n, err := lexParam(&b, src[i:])
+			if err != nil {
+				return nil, nil, err
+			}
+			i += n
+
+		default:
+			b.lit = append(b.lit, c)
+			i++
+		}
+	}
+	if stop != 0 {
+		// The caller sets the position and the reason.
+		return nil, nil, incompleteError(nil, "")
+	}
+	return b.word(), src[i:], nil
+}
+
+// lexLiteral returns the word at the beginning of src when it is literal text,
+// without quotes or only enclosed in single quotes, which is the usual case;
+// it is faster than the full lexing. It returns the length of the word, or -1
+// if the word is not such literal.
+func lexLiteral(src []byte, inArray bool) (word, int) {
+	start, end := 0, 0
+	if len(src) != 0 && src[0] == '\'' {
+		end = bytes.IndexByte(src[1:], '\'')
+		if end == -1 {
+			return nil, -1
+		}
+		start, end = 1, end+1
+	} else {
+		for end < len(src) && strings.IndexByte(" \t\r\n\\'\"$`;&|<>()", src[end]) == -1 {
+			end++
+		}
+	}
+
+	n := end + start // after of the closing quote, if any
+	if n < len(src) {
+		switch c := src[n]; {
+		case c == ' ', c == '\t', c == '\n':
+		case c == ')' && inArray:
+		default:
+			return nil, -1
+		}
+	}
+	return word{{lit: string(src[start:end])}}, n
+}
+
+// lexDQuote adds to b the text quoted with double quotes at the beginning of
+// src, handling the backslash escapes. It returns the number of bytes read,
+// with the quotes.
+func lexDQuote(b *wordBuilder, src []byte) (int, error) {
+	for i := 1; i < len(src); {
+		switch c := src[i]; c {
+		case '"':
+			return i + 1, nil
+		case '\\':
+			if i+1 == len(src) {
+				return 0, incompleteError(src, "unterminated double quote")
+			}
+			switch src[i+1] {
+			case '$', '`', '"', '\\':
+				b.lit = append(b.lit, src[i+1])
+			case '\n':
+			default:
+				b.lit = append(b.lit, c, src[i+1])
+			}
+			i += 2
+		case '$', '`':
+			n, err := lexParam(b, src[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		default:
+			b.lit = append(b.lit, c)
+			i++
+		}
+	}
+	return 0, incompleteError(src, "unterminated double quote")
+}
+
+// lexParam adds to b the parameter expansion or command substitution at the
+// beginning of src, and returns its length. A "$" which does not start an
+// expansion is added as literal text.
+func lexParam(b *wordBuilder, src []byte) (int, error) {
+	p := &param{rest: len(src)}
+	n := 0
+
+	switch {
+	case src[0] == '`':
+		end := bytes.IndexByte(src[1:], '`')
+		if end == -1 {
+			return 0, incompleteError(src, "unterminated command substitution")
+		}
+		p.name, n = "(", end+2
+
+	case len(src) == 1:
+		b.lit = append(b.lit, '$')
+		return 1, nil
+
+	case src[1] == '(':
+		depth := 0
+		for i := 1; i < len(src) && n == 0; i++ {
+			switch src[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					n = i + 1
+				}
+			case '\\':
+				i++
+			case '\'':
+				end := bytes.IndexByte(src[i+1:], '\'')
+				if end == -1 {
+					return 0, incompleteError(src, "unterminated command substitution")
+				}
+				i += end + 1
+			}
+		}
+		if n == 0 {
+			return 0, incompleteError(src, "unterminated command substitution")
+		}
+		p.name = "("
+
+	case src[1] == '{':
+		i := 2
+		for i < len(src) && isNameChar(src[i], i == 2) {
+			i++
+		}
+		if i == 2 && i < len(src) && strings.IndexByte(specialParams, src[i]) != -1 {
+			i++
+		}
+		p.name = string(src[2:i])
+
+		if i < len(src) && src[i] == ':' {
+			p.op = ":"
+			i++
+		}
+		if i == len(src) {
+			return 0, incompleteError(src, "unterminated parameter expansion")
+		}
+		switch src[i] {
+		case '-', '=', '?', '+':
+			p.op += string(src[i])
+			arg, rest, err := lexWord(src[i+1:], '}')
+			if err != nil {
+				if e := err.(*lexError); e.reason == "" {
+					return 0, incompleteError(src, "unterminated parameter expansion")
+				}
+				return 0, err
+			}
+			p.arg = arg
+			n = len(src) - len(rest)
+		case '}':
+			if p.op != "" {
+				return 0, newLexError(src, "bad substitution: "+string(src[:i+1]))
+			}
+			n = i + 1
+		default:
+			return 0, newLexError(src, "bad substitution: "+string(src[:i+1]))
+		}
+		if p.name == "" {
+			return 0, newLexError(src, "bad substitution: "+string(src[:n]))
+		}
+
+	case isNameChar(src[1], true):
+		n = 2
+		for n < len(src) && isNameChar(src[n], false) {
+			n++
+		}
+		p.name = string(src[1:n])
+
+	case strings.IndexByte(specialParams, src[1]) != -1 || ('0' <= src[1] && src[1] <= '9'):
+		p.name, n = string(src[1]), 2
+
+	default:
+		b.lit = append(b.lit, '$')
+		return 1, nil
+	}
+
+	p.raw = string(src[:n])
+	b.addParam(p)
+	return n, nil
+}
+
+// specialParams are the characters of the special parameters of the shell.
+const specialParams = "@*#?-$!0123456789"
+
+// trimComment returns the text of a comment without the "#" characters, the
+// leading spaces and the line ending.
+func trimComment(b []byte) []byte {
+	b = bytes.TrimLeft(b, "#")
+	b = bytes.TrimLeft(b, " \t")
+	return bytes.TrimRight(b, "\r\n")
+}
+
+func isEOL(b []byte) bool {
+	return len(b) == 0 || b[0] == '\n' || (b[0] == '\r' && len(b) > 1 && b[1] == '\n')
+}
+
+// isNameChar reports whether c can be used in a variable name.
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') ||
+		(!first && '0' <= c && c <= '9')
+}
+
+// safeChars are the characters that do not need to be quoted in a value.
+const safeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"0123456789_@%+=:,./-"
+
+// Quote returns the value quoted so a shell reads it as it is. The value is
+// returned unchanged when it has no special characters, else it is enclosed
+// in double quotes.
+func Quote(value string) string {
+	if value != "" && strings.Trim(value, safeChars) == "" {
+		return value
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '$', '`', '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
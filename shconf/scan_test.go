@@ -0,0 +1,117 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	const input = `# Server.
+
+# Network.
+HOST=localhost
+PORT=80  # port to listen
+. other.conf
+
+NAME="my
+server"
+MODULES=(a 'b c')
+`
+	want := []Entry{
+		{"HOST", "localhost", "Network.", 4},
+		{"PORT", "80", "port to listen", 5},
+		{"NAME", "my\nserver", "", 8},
+		{"MODULES", "a b c", "", 10},
+	}
+
+	var got []Entry
+	s := NewScanner(strings.NewReader(input))
+	for s.Scan() {
+		got = append(got, s.Entry())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	s = NewScanner(strings.NewReader("A=1\nB=1 2\nC=3\n"))
+	n := 0
+	for s.Scan() {
+		n++
+	}
+	if e, ok := s.Err().(*ParseError); !ok || e.Line != 2 || n != 1 {
+		t.Errorf("got %d keys and error %v, want 1 key and error in line 2", n, s.Err())
+	}
+	if s.Scan() {
+		t.Error("Scan after of an error got true")
+	}
+}
+
+// genFile returns a configuration with n keys, with comments above of every
+// ten keys, and values with and without quotes.
+func genFile(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Generated file.\n\n")
+
+	for i := 0; i < n; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(&b, "\n# Group %d.\n", i/10)
+		}
+		switch i % 4 {
+		case 0:
+			fmt.Fprintf(&b, "KEY_%d=value%d\n", i, i)
+		case 1:
+			fmt.Fprintf(&b, "KEY_%d=\"value with spaces %d\"\n", i, i)
+		case 2:
+			fmt.Fprintf(&b, "KEY_%d='/usr/local/lib/%d'  # path\n", i, i)
+		case 3:
+			fmt.Fprintf(&b, "export KEY_%d=%d\n", i, i)
+		}
+	}
+	return b.Bytes()
+}
+
+func benchmarkParse(b *testing.B, n int) {
+	data := genFile(n)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseBytes(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkScanner(b *testing.B, n int) {
+	data := genFile(n)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s := NewScanner(bytes.NewReader(data))
+		for s.Scan() {
+		}
+		if err := s.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParse1K(b *testing.B)    { benchmarkParse(b, 1000) }
+func BenchmarkParse50K(b *testing.B)   { benchmarkParse(b, 50000) }
+func BenchmarkScanner1K(b *testing.B)  { benchmarkScanner(b, 1000) }
+func BenchmarkScanner50K(b *testing.B) { benchmarkScanner(b, 50000) }
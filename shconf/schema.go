@@ -0,0 +1,384 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Type is the type of the value of a key, in a schema.
+type Type int
+
+const (
+	TypeString Type = iota
+	TypeBool
+	TypeInt
+	TypeFloat
+	TypeEnum   // one of the values in Key.Values
+	TypePath   // name of a file
+	TypeRegexp // regular expression, with the syntax of package regexp
+)
+
+var typeNames = [...]string{"string", "bool", "int", "float", "enum", "path", "regexp"}
+
+func (t Type) String() string {
+	if t < 0 || int(t) >= len(typeNames) {
+		return "Type(" + strconv.Itoa(int(t)) + ")"
+	}
+	return typeNames[t]
+}
+
+// A Key describes a key allowed in the configuration.
+type Key struct {
+	Name     string
+	Type     Type
+	Required bool
+	Default  string // value used when the key is not set; empty if there is none
+
+	// Range of the value of a TypeInt or a TypeFloat, or of the length of a
+	// TypeString, in the form "MIN..MAX"; any of both limits can be omitted,
+	// as "1..".
+	Range string
+
+	Values []string // allowed values of a TypeEnum
+	Exist  bool     // whether the file of a TypePath has to exist
+	Doc    string
+}
+
+// A Schema describes the keys allowed in a configuration.
+type Schema struct {
+	keys  []*keyRule
+	index map[string]*keyRule
+}
+
+// A keyRule is a Key ready to check values.
+type keyRule struct {
+	Key
+	min, max float64 // limits of the range, which are infinite if not given
+}
+
+// NewSchema returns the schema with the given keys. It returns an error if a
+// key is defined twice, or if its range or default value are not valid.
+func NewSchema(keys ...Key) (*Schema, error) {
+	s := &Schema{index: make(map[string]*keyRule)}
+
+	for _, k := range keys {
+		if !isName(k.Name) {
+			return nil, errors.New("schema: invalid key name: " + k.Name)
+		}
+		if _, found := s.index[k.Name]; found {
+			return nil, errors.New("schema: key defined twice: " + k.Name)
+		}
+		if k.Type < TypeString || k.Type > TypeRegexp {
+			return nil, fmt.Errorf("schema: key %s: invalid type %s", k.Name, k.Type)
+		}
+		if k.Type == TypeEnum && len(k.Values) == 0 {
+			return nil, errors.New("schema: key " + k.Name + ": enum without values")
+		}
+
+		r := &keyRule{Key: k, min: math.Inf(-1), max: math.Inf(1)}
+		if k.Range != "" {
+			if k.Type != TypeInt && k.Type != TypeFloat && k.Type != TypeString {
+				return nil, fmt.Errorf("schema: key %s: range in type %s", k.Name, k.Type)
+			}
+			if err := r.parseRange(); err != nil {
+				return nil, errors.New("schema: key " + k.Name + ": " + err.Error())
+			}
+		}
+		if k.Default != "" {
+			if err := r.check(k.Default); err != nil {
+				return nil, errors.New("schema: key " + k.Name + ": default value: " + err.Error())
+			}
+		}
+
+		s.keys = append(s.keys, r)
+		s.index[k.Name] = r
+	}
+	return s, nil
+}
+
+// LoadSchema loads the schema from the named file, which is a configuration
+// file where every key is described by its value, as in:
+//
+//	# Port of the server.
+//	PORT='int range=1..65535 default=80'
+//	LOG_LEVEL='enum values=debug,info,error required'
+//	ROOT='path exist'
+//
+// The value starts with the type, followed by the options "required",
+// "default=VALUE", "range=MIN..MAX", "values=A,B,..." and "exist"; an option
+// is quoted for the shell if it has blanks. The comment above a key, if any,
+// is its documentation.
+func LoadSchema(name string) (*Schema, error) {
+	cfg, err := ParseFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(cfg.data))
+	for _, line := range cfg.lines {
+		if line.kind != assignLine {
+			continue
+		}
+		k, err := parseKey(line.key, line.value)
+		if err != nil {
+			return nil, line.error(name, len(line.raw), err.Error())
+		}
+		k.Doc = cfg.docAbove(line)
+		keys = append(keys, k)
+	}
+	return NewSchema(keys...)
+}
+
+// parseKey returns the key name described by spec.
+func parseKey(name, spec string) (Key, error) {
+	k := Key{Name: name}
+	var fields []string
+
+	for text := []byte(strings.TrimSpace(spec)); len(text) != 0; {
+		w, rest, err := lexWord(text, 0)
+		if err != nil {
+			return k, errors.New("invalid description: " + spec)
+		}
+		fields = append(fields, w.String())
+		text = []byte(strings.TrimLeft(string(rest), " \t\n"))
+	}
+	if len(fields) == 0 {
+		return k, errors.New("missing type")
+	}
+
+	typ := -1
+	for i, n := range typeNames {
+		if fields[0] == n {
+			typ = i
+		}
+	}
+	if typ == -1 {
+		return k, errors.New("unknown type " + fields[0])
+	}
+	k.Type = Type(typ)
+
+	for _, opt := range fields[1:] {
+		switch {
+		case opt == "required":
+			k.Required = true
+		case opt == "exist":
+			k.Exist = true
+		case strings.HasPrefix(opt, "default="):
+			k.Default = opt[8:]
+		case strings.HasPrefix(opt, "range="):
+			k.Range = opt[6:]
+		case strings.HasPrefix(opt, "values="):
+			k.Values = strings.Split(opt[7:], ",")
+		default:
+			return k, errors.New("unknown option " + opt)
+		}
+	}
+	return k, nil
+}
+
+// Key returns the description of the key name, if it is in the schema.
+func (s *Schema) Key(name string) (k Key, found bool) {
+	r, found := s.index[name]
+	if !found {
+		return Key{}, false
+	}
+	return r.Key, true
+}
+
+// Keys returns the descriptions of the keys, in the order of the schema.
+func (s *Schema) Keys() []Key {
+	keys := make([]Key, len(s.keys))
+	for i, r := range s.keys {
+		keys[i] = r.Key
+	}
+	return keys
+}
+
+// Validate checks the configuration against the schema. It returns every
+// value which does not conform to the schema, every required key which is
+// not set, and every key which is not in the schema, as an ErrorList.
+func (s *Schema) Validate(cfg *Config) error {
+	cfg.RLock()
+	defer cfg.RUnlock()
+
+	var errs ErrorList
+	errorf := func(key, format string, args ...interface{}) {
+		e := &ParseError{Filename: cfg.filename, Reason: fmt.Sprintf(format, args...)}
+		if key != "" {
+			line := cfg.external(key)
+			if line == nil {
+				line = cfg.find(key)
+			}
+			e.Filename, e.Line = line.file, line.line
+		}
+		errs = append(errs, e)
+	}
+
+	for _, key := range cfg.keys() {
+		r, found := s.index[key]
+		if !found {
+			if near := s.suggest(key); near != "" {
+				errorf(key, "unknown key %s; did you mean %s?", key, near)
+			} else {
+				errorf(key, "unknown key %s", key)
+			}
+			continue
+		}
+		if err := r.check(cfg.data[key]); err != nil {
+			if cfg.secret[key] {
+				// The error could show the value.
+				errorf(key, "key %s: invalid secret value", key)
+			} else {
+				errorf(key, "key %s: %s", key, err)
+			}
+		}
+	}
+	for _, r := range s.keys {
+		if _, found := cfg.data[r.Name]; !found && r.Required {
+			errorf("", "required key %s is not set", r.Name)
+		}
+	}
+	return errs.err()
+}
+
+// check checks that the value v conforms to the key.
+func (r *keyRule) check(v string) error {
+	switch r.Type {
+	case TypeBool:
+		if _, err := strconv.ParseBool(v); err != nil {
+			return errors.New("invalid bool value: " + strconv.Quote(v))
+		}
+	case TypeInt:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errors.New("invalid int value: " + strconv.Quote(v))
+		}
+		return r.checkRange(float64(n), v)
+	case TypeFloat:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errors.New("invalid float value: " + strconv.Quote(v))
+		}
+		return r.checkRange(n, v)
+	case TypeString:
+		if r.Range != "" {
+			return r.checkRange(float64(utf8.RuneCountInString(v)), "length "+
+				strconv.Itoa(utf8.RuneCountInString(v)))
+		}
+	case TypeEnum:
+		for _, allowed := range r.Values {
+			if v == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("value %q is not one of %s", v, strings.Join(r.Values, ", "))
+	case TypePath:
+		if v == "" || strings.IndexByte(v, 0) != -1 {
+			return errors.New("invalid path: " + strconv.Quote(v))
+		}
+		if r.Exist {
+			if _, err := os.Stat(v); err != nil {
+				return err
+			}
+		}
+	case TypeRegexp:
+		if _, err := regexp.Compile(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRange checks that n is in the range of the key; v is the text of n.
+func (r *keyRule) checkRange(n float64, v string) error {
+	if n < r.min || n > r.max {
+		return fmt.Errorf("%s out of range %s", v, r.Range)
+	}
+	return nil
+}
+
+// parseRange sets the limits of the range of the key.
+func (r *keyRule) parseRange() error {
+	i := strings.Index(r.Range, "..")
+	if i == -1 {
+		return errors.New("invalid range " + r.Range)
+	}
+	lo, hi := r.Range[:i], r.Range[i+2:]
+	var err error
+
+	if lo != "" {
+		if r.min, err = strconv.ParseFloat(lo, 64); err != nil {
+			return errors.New("invalid range " + r.Range)
+		}
+	}
+	if hi != "" {
+		if r.max, err = strconv.ParseFloat(hi, 64); err != nil {
+			return errors.New("invalid range " + r.Range)
+		}
+	}
+	if r.min > r.max {
+		return errors.New("invalid range " + r.Range)
+	}
+	return nil
+}
+
+// suggest returns the key of the schema nearest to key, if it could be a
+// typo of it, or the empty string.
+func (s *Schema) suggest(key string) string {
+	best, bestDist := "", 3 // at most 2 edits
+	for _, r := range s.keys {
+		d := editDistance(strings.ToUpper(key), strings.ToUpper(r.Name))
+		if d < bestDist && d < len(r.Name) {
+			best, bestDist = r.Name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if n := prev[j] + 1; n < cur[j] {
+				cur[j] = n
+			}
+			if n := cur[j-1] + 1; n < cur[j] {
+				cur[j] = n
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// SetSchema sets the schema of the configuration, whose default values are
+// returned by the getters for the keys which are not set.
+func (c *Config) SetSchema(s *Schema) {
+	c.Lock()
+	c.schema = s
+	c.Unlock()
+}
@@ -0,0 +1,126 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+const schemaFile = `# Name of the server.
+HOST='string range=1..255 required'
+
+# Port of the server.
+PORT='int range=1..65535 default=80'
+RATIO='float range=0..1 default=0.5'
+DEBUG='bool default=false'
+LOG_LEVEL='enum values=debug,info,error'
+ROOT='path'
+MATCH="regexp default='^a.*'"
+`
+
+func TestSchema(t *testing.T) {
+	s, err := LoadSchema(tempFile(t, schemaFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, found := s.Key("PORT")
+	want := Key{Name: "PORT", Type: TypeInt, Default: "80", Range: "1..65535", Doc: "Port of the server."}
+	if !found || !reflect.DeepEqual(k, want) {
+		t.Errorf("Key(PORT) got %+v, want %+v", k, want)
+	}
+	if k, _ = s.Key("MATCH"); k.Type != TypeRegexp || k.Default != "^a.*" {
+		t.Errorf("Key(MATCH) got %+v", k)
+	}
+	if k, _ = s.Key("LOG_LEVEL"); !reflect.DeepEqual(k.Values, []string{"debug", "info", "error"}) {
+		t.Errorf("Key(LOG_LEVEL) got values %q", k.Values)
+	}
+	if n := len(s.Keys()); n != 7 {
+		t.Errorf("Keys got %d keys, want 7", n)
+	}
+
+	cfg, err := ParseString(`PORT=0
+RATIO=0.25
+DEBUG=maybe
+LOG_LEVEL=warn
+ROOT=/srv
+MATCH='a('
+PROT=80
+OTHER=1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Validate(cfg)
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("Validate got %v, want ErrorList", err)
+	}
+	want2 := []string{
+		"required key HOST is not set",
+		"line 1: key PORT: 0 out of range 1..65535",
+		"line 3: key DEBUG: invalid bool value: \"maybe\"",
+		"line 4: key LOG_LEVEL: value \"warn\" is not one of debug, info, error",
+		"line 6: key MATCH: error parsing regexp: missing closing ): `a(`",
+		"line 7: unknown key PROT; did you mean PORT?",
+		"line 8: unknown key OTHER",
+	}
+	if len(list) != len(want2) {
+		t.Fatalf("Validate got %d errors, want %d: %v", len(list), len(want2), list)
+	}
+	for i, e := range list {
+		if e.Error() != want2[i] {
+			t.Errorf("error %d got %q, want %q", i, e, want2[i])
+		}
+	}
+
+	if cfg, err = ParseString("HOST=localhost\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Validate(cfg); err != nil {
+		t.Errorf("Validate got %v", err)
+	}
+
+	// The getters use the default values of the schema.
+	if _, err = cfg.Int("PORT"); !isNotFound(err) {
+		t.Errorf("Int(PORT) without schema got error %v", err)
+	}
+	cfg.SetSchema(s)
+	if v, err := cfg.Int("PORT"); v != 80 || err != nil {
+		t.Errorf("Int(PORT) got %v, %v", v, err)
+	}
+	if v, err := cfg.Float("RATIO"); v != 0.5 || err != nil {
+		t.Errorf("Float(RATIO) got %v, %v", v, err)
+	}
+	if v, err := cfg.Bool("DEBUG"); v || err != nil {
+		t.Errorf("Bool(DEBUG) got %v, %v", v, err)
+	}
+	if _, err = cfg.Bool("ROOT"); !isNotFound(err) {
+		t.Errorf("Bool(ROOT) got error %v", err)
+	}
+}
+
+func TestNewSchema(t *testing.T) {
+	for _, k := range []Key{
+		{Name: "1A"},
+		{Name: "A", Type: TypeEnum},
+		{Name: "A", Type: TypeBool, Range: "1..2"},
+		{Name: "A", Type: TypeInt, Range: "2..1"},
+		{Name: "A", Type: TypeInt, Range: "1-2"},
+		{Name: "A", Type: TypeInt, Range: "1..10", Default: "20"},
+		{Name: "A", Type: TypeEnum, Values: []string{"a"}, Default: "b"},
+	} {
+		if _, err := NewSchema(k); err == nil {
+			t.Errorf("NewSchema(%+v) expected error", k)
+		}
+	}
+	if _, err := NewSchema(Key{Name: "A"}, Key{Name: "A"}); err == nil {
+		t.Error("NewSchema with a key twice expected error")
+	}
+}
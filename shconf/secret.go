@@ -0,0 +1,142 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A key has a secret value when its name matches a pattern in Parser.Secrets,
+// or when the comment above it, or after its value, has the note "@secret":
+//
+//	# Password of the database. @secret
+//	DB_PASSWORD=...
+//
+// The secret values are redacted in the output of fmt. At Close, the text of
+// their lines is overwritten with zeros and the references to their values
+// are dropped; the strings with the values are not overwritten, since Go
+// strings can not be changed, so they stay in memory until the garbage
+// collector reuses it. A Config can not be edited nor written after of Close,
+// since the secret keys are not in it any more.
+
+// Redacted is shown instead of a secret value.
+const Redacted = "********"
+
+// hasSecretNote reports whether the comment has the note of secret value.
+func hasSecretNote(comment string) bool {
+	if !strings.Contains(comment, "@secret") {
+		return false
+	}
+	for _, f := range strings.Fields(comment) {
+		if f == "@secret" {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecret reports whether key matches a pattern of the secret keys.
+func (p *Parser) isSecret(key string) bool {
+	for _, pattern := range p.Secrets {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setSecrets sets the secret keys from the assignments. In CheckPerm mode, it
+// checks the permissions of the files with secret values.
+func (c *Config) setSecrets(assigns []*logicalLine) error {
+	checked := make(map[string]bool)
+
+	for _, line := range assigns {
+		if !line.secret {
+			continue
+		}
+		if c.secret == nil {
+			c.secret = make(map[string]bool)
+		}
+		c.secret[line.key] = true
+
+		if c.parser.Mode&CheckPerm != 0 && line.file != "" && !checked[line.file] {
+			if err := checkPerm(line.file); err != nil {
+				return err
+			}
+			checked[line.file] = true
+		}
+	}
+	return nil
+}
+
+// IsSecret reports whether the value of key is secret.
+func (c *Config) IsSecret(key string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.secret[key]
+}
+
+// Redacted returns the value for a given key, or Redacted if it is secret.
+func (c *Config) Redacted(key string) string {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.secret[key] {
+		return Redacted
+	}
+	return c.data[key]
+}
+
+// Format implements fmt.Formatter; it writes the keys and values, with the
+// secret values redacted, as "{A=1 B=********}".
+func (c *Config) Format(f fmt.State, verb rune) {
+	c.RLock()
+	defer c.RUnlock()
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range c.keys() {
+		if i != 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		if c.secret[key] {
+			b.WriteString(Redacted)
+		} else {
+			b.WriteString(Quote(c.data[key]))
+		}
+	}
+	b.WriteByte('}')
+	f.Write(b.Bytes())
+}
+
+// zeroSecrets removes the secret values. The text of the lines is overwritten
+// with zeros, and the references to the values are dropped.
+func (c *Config) zeroSecrets() {
+	if len(c.secret) == 0 {
+		return
+	}
+	lines := append(c.lines[:len(c.lines):len(c.lines)], c.externals...)
+
+	for _, line := range lines {
+		if line.kind != assignLine || !c.secret[line.key] {
+			continue
+		}
+		for i := range line.raw {
+			line.raw[i] = 0
+		}
+		line.raw, line.tail = nil, nil
+		line.value, line.word, line.elems = "", nil, nil
+	}
+	for key := range c.secret {
+		delete(c.data, key)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const secretFile = `HOST=localhost
+DB_PASSWORD='pa ss'
+
+# Token of the API.
+# @secret
+TOKEN=abc
+KEY=xyz # @secret
+
+# @secret
+
+OTHER=1
+`
+
+func TestSecret(t *testing.T) {
+	p := &Parser{Secrets: []string{"*_PASSWORD"}}
+	cfg, err := p.Parse(strings.NewReader(secretFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{
+		"HOST": false, "DB_PASSWORD": true, "TOKEN": true, "KEY": true, "OTHER": false,
+	} {
+		if cfg.IsSecret(key) != want {
+			t.Errorf("IsSecret(%s) got %v", key, !want)
+		}
+	}
+	if v := cfg.Redacted("TOKEN"); v != Redacted {
+		t.Errorf("Redacted(TOKEN) got %q", v)
+	}
+	if v := cfg.Redacted("HOST"); v != "localhost" {
+		t.Errorf("Redacted(HOST) got %q", v)
+	}
+	if v := cfg.String("TOKEN"); v != "abc" {
+		t.Errorf("String(TOKEN) got %q", v)
+	}
+
+	want := "{HOST=localhost DB_PASSWORD=******** TOKEN=******** KEY=******** OTHER=1}"
+	for _, format := range []string{"%v", "%s", "%+v"} {
+		if got := fmt.Sprintf(format, cfg); got != want {
+			t.Errorf("%s got %q, want %q", format, got, want)
+		}
+	}
+
+	s, err := NewSchema(Key{Name: "HOST"}, Key{Name: "DB_PASSWORD", Type: TypeInt},
+		Key{Name: "TOKEN"}, Key{Name: "KEY"}, Key{Name: "OTHER"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Validate(cfg); err == nil || strings.Contains(err.Error(), "pa ss") {
+		t.Errorf("Validate got error %v", err)
+	}
+
+	raw := cfg.find("TOKEN").raw
+	if err = cfg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Trim(string(raw), "\x00") != "" {
+		t.Errorf("the line was not zeroed: %q", raw)
+	}
+	if cfg.Has("TOKEN") || cfg.Has("DB_PASSWORD") || !cfg.Has("HOST") {
+		t.Errorf("after Close got keys %v", cfg.Keys())
+	}
+}
+
+func TestSecretClose(t *testing.T) {
+	name := tempFile(t, "# @secret\nPW=hunter2\nA=1")
+	cfg, err := new(Parser).ParseFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, err := range []error{
+		cfg.SetValue("A", "2"),
+		cfg.AddKey("B", "1", ""),
+		cfg.DeleteKey("A"),
+		cfg.RenameKey("A", "B"),
+		cfg.WriteValue("A", "2"),
+		cfg.Save(),
+	} {
+		if err != ErrClosed {
+			t.Errorf("#%d got error %v, want ErrClosed", i, err)
+		}
+	}
+	if _, err = cfg.WriteTo(ioutil.Discard); err != ErrClosed {
+		t.Errorf("WriteTo got error %v", err)
+	}
+	if cfg.String("A") != "1" {
+		t.Errorf("got value %q after Close", cfg.String("A"))
+	}
+
+	// The secret values are not read again.
+	if err = cfg.Watch(0); err != ErrClosed {
+		t.Errorf("Watch got error %v", err)
+	}
+	cfg.reload()
+	if v := cfg.String("PW"); v != "" {
+		t.Errorf("got secret value %q after reload", v)
+	}
+
+	// The secret keys are kept in the file.
+	if got, _ := ioutil.ReadFile(name); string(got) != "# @secret\nPW=hunter2\nA=1\n" {
+		t.Errorf("file got %q", got)
+	}
+}
+
+func TestCheckPerm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the permissions are not checked in Windows")
+	}
+	name := tempFile(t, "A=1\nPASS=x # @secret")
+	p := &Parser{Mode: CheckPerm}
+
+	if err := os.Chmod(name, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ParseFile(name); err == nil || !strings.Contains(err.Error(), "readable by others") {
+		t.Errorf("mode 0644 got error %v", err)
+	}
+	if err := os.Chmod(name, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ParseFile(name); err != nil {
+		t.Errorf("mode 0600 got error %v", err)
+	}
+
+	// Files without secrets are not checked.
+	name = tempFile(t, "A=1")
+	os.Chmod(name, 0644)
+	if _, err := p.ParseFile(name); err != nil {
+		t.Errorf("file without secrets got error %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build linux
+// +build linux
+
+package shconf
+
+import (
+	"os"
+	"syscall"
+)
+
+const selinuxXattr = "security.selinux"
+
+// copySELinux sets the SELinux security context of the named file to file.
+func copySELinux(name string, file *os.File) error {
+	buf := make([]byte, 256)
+
+	for {
+		n, err := syscall.Getxattr(name, selinuxXattr, buf)
+		switch err {
+		case nil:
+			return syscall.Setxattr(file.Name(), selinuxXattr, buf[:n], 0)
+		case syscall.ERANGE:
+			buf = make([]byte, len(buf)*2)
+		case syscall.ENODATA, syscall.ENOTSUP:
+			return nil
+		default:
+			return &os.PathError{Op: "getxattr", Path: name, Err: err}
+		}
+	}
+}
@@ -7,132 +7,474 @@
 // Package shconf implements a shell-variable configuration parser.
 //
 // The configuration file consists on entries with the format "key"="value".
-// The comments are indicated by "#" at the beginning of a line and upon the keys;
-// the first comment is about the file.
+// The values follow the quoting rules of the POSIX shell so the same file can
+// be sourced by a shell.
+// The comments are indicated by "#" at the beginning of a line and upon the keys,
+// or after of a value; the first comment, followed by a blank line, is about
+// the file.
 package shconf
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"io"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
-	"unicode"
-
-	"github.com/kless/shout/file"
-)
-
-var (
-	bComment = []byte{'#'}
-	bEmpty   = []byte{}
-	bEqual   = []byte{'='}
-	bDQuote  = []byte{'"'}
+	"time"
 )
 
 // A Config represents the configuration.
 type Config struct {
-	filename string
-	comment  map[int][]string  // id: []{comment, key...}; id 1 is for main comment.
-	data     map[string]string // key: value
-	offset   map[string]int64  // key: offset; for editing.
+	filename  string
+	comment   []Group           // the first one is the main comment, without keys.
+	data      map[string]string // key: value
+	lines     []*logicalLine    // all lines of the file, for editing.
+	backup    BackupMode
+	parser    Parser         // used to reload the file
+	externals []*logicalLine // assignments in other files, which set the values
+	files     []string       // names of the files read, the main one first
+	schema    *Schema
+	secret    map[string]bool // keys with secret values
+	watch     *watcher
+	conflict  ConflictMode
+	stamp     fileStamp         // file as it was read or written
+	orig      map[string]uint64 // sums of the assignments read
+	closed    bool              // whether Close has been called
 	sync.RWMutex
 }
 
+// A Mode value is a set of flags (or 0). They control the parsing.
+type Mode uint
+
+const (
+	// Expand expands the references to keys, as "$KEY" or "${KEY:-default}",
+	// in the values.
+	Expand Mode = 1 << iota
+
+	// ExpandEnv looks up in the environment the references which are not
+	// keys of the file; it implies Expand.
+	ExpandEnv
+
+	// Strict reports all the errors found in the file, as an ErrorList,
+	// instead of stopping at the first one.
+	Strict
+
+	// Source reads the files included with the lines ". FILE" and
+	// "source FILE", such as the shell does.
+	Source
+
+	// DropIn reads the files with extension ".conf" in the directory named
+	// as the file plus ".d", in lexical order and after of the file.
+	DropIn
+
+	// CheckPerm refuses the files with secret values which can be read by
+	// the group or by others.
+	CheckPerm
+)
+
+// A Parser parses configuration files.
+// The zero value parses the files without expanding the values.
+type Parser struct {
+	Mode Mode
+
+	// LookupEnv is used to look up the environment variables when the mode
+	// is ExpandEnv. If it is nil, os.LookupEnv is used.
+	LookupEnv func(key string) (string, bool)
+
+	// Secrets are the patterns of the names of the keys with secret values,
+	// with the syntax of path.Match, as "*_PASSWORD".
+	Secrets []string
+}
+
 // ParseFile creates a new Config and parses the file configuration from the
 // named file.
+//
+// The values are unquoted such as it is done by a POSIX shell, so they can be
+// quoted with single or double quotes, and escaped with backslashes; a value
+// can span several lines.
 func ParseFile(name string) (*Config, error) {
+	return new(Parser).ParseFile(name)
+}
+
+// Parse creates a new Config and parses the configuration read from r.
+// The Config is not backed by a file, so it can not be written with
+// WriteValue.
+func Parse(r io.Reader) (*Config, error) {
+	return new(Parser).Parse(r)
+}
+
+// ParseBytes creates a new Config and parses the configuration in b.
+func ParseBytes(b []byte) (*Config, error) {
+	return new(Parser).Parse(bytes.NewReader(b))
+}
+
+// ParseString creates a new Config and parses the configuration in s.
+func ParseString(s string) (*Config, error) {
+	return new(Parser).Parse(strings.NewReader(s))
+}
+
+// ParseFile creates a new Config and parses the file configuration from the
+// named file, according to the parser's mode.
+//
+// With the mode Expand, the references to keys are resolved to the value of
+// the key defined above; the supported forms are "$KEY", "${KEY}",
+// "${KEY-word}", "${KEY:-word}", "${KEY=word}", "${KEY:=word}",
+// "${KEY+word}", "${KEY:+word}", "${KEY?msg}" and "${KEY:?msg}"; as in a
+// shell, "${KEY=word}" assigns word to KEY, if it is unset, for the
+// references after of it. A reference to a key which is not defined above is
+// looked up in the environment with the mode ExpandEnv, and else it is unset,
+// such as in a shell.
+func (p *Parser) ParseFile(name string) (*Config, error) {
 	file, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
+
+	h := sha256.New()
+	cfg, err := p.parse(io.TeeReader(file, h), file.Name())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.stamp, err = newStamp(name, h.Sum(nil)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse creates a new Config and parses the configuration read from r,
+// according to the parser's mode.
+func (p *Parser) Parse(r io.Reader) (*Config, error) {
+	return p.parse(r, "")
+}
 
+// parse parses the configuration read from r; filename is the name of the
+// file which backs the Config, if any.
+//
+// A syntax error is returned as a *ParseError, or as an ErrorList in Strict
+// mode; the keys can not be defined twice in the same file.
+func (p *Parser) parse(r io.Reader, filename string) (*Config, error) {
 	cfg := &Config{
-		file.Name(),
-		make(map[int][]string),
-		make(map[string]string),
-		make(map[string]int64),
-		sync.RWMutex{},
+		filename: filename,
+		parser:   *p,
 	}
 	cfg.Lock()
 	defer cfg.Unlock()
-	defer file.Close()
 
-	var comment bytes.Buffer
-	buf := bufio.NewReader(file)
+	st := &parseState{p: p}
+	lines, err := st.readFile(r, filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg.lines = lines
+
+	if p.Mode&DropIn != 0 && filename != "" {
+		if err = st.readDropIns(filename + ".d"); err != nil {
+			return nil, err
+		}
+	}
 
-	for nComment, off := 0, int64(1); ; {
-		line, _, err := buf.ReadLine()
-		if err == io.EOF {
-			break
+	if p.Mode&(Expand|ExpandEnv) != 0 {
+		var lookupEnv func(string) (string, bool)
+		if p.Mode&ExpandEnv != 0 {
+			if lookupEnv = p.LookupEnv; lookupEnv == nil {
+				lookupEnv = os.LookupEnv
+			}
+		}
+		var list *ErrorList
+		if p.Mode&Strict != 0 {
+			list = &st.errs
 		}
-		if bytes.Equal(line, bEmpty) {
-			continue
+		if err = expand(st.assigns, lookupEnv, list); err != nil {
+			return nil, err
+		}
+	}
+	if err = st.errs.err(); err != nil {
+		return nil, err
+	}
+
+	// The last assignment of a key wins.
+	cfg.data = make(map[string]string, len(st.assigns))
+	last := make(map[string]*logicalLine, len(st.assigns))
+	for _, line := range st.assigns {
+		cfg.data[line.key] = line.value
+		last[line.key] = line
+	}
+	if err = cfg.setSecrets(st.assigns); err != nil {
+		return nil, err
+	}
+	for _, line := range st.assigns {
+		if last[line.key] == line && line.file != filename {
+			cfg.externals = append(cfg.externals, line)
 		}
+	}
+	cfg.files = st.read
+	cfg.comment = groupComments(cfg.lines)
+	cfg.orig = lineSums(cfg.lines)
+	return cfg, nil
+}
+
+// KeyNotFoundError is returned when a key is not in the configuration.
+type KeyNotFoundError struct {
+	Key string
+}
+
+func (e *KeyNotFoundError) Error() string { return "key not found: " + e.Key }
 
-		off += int64(len(line))
+// Has reports whether the key is in the configuration.
+func (c *Config) Has(key string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	_, found := c.data[key]
+	return found
+}
+
+// Keys returns the keys in the order of the file, followed by the keys which
+// are only set in other files.
+func (c *Config) Keys() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.keys()
+}
 
-		if bytes.HasPrefix(line, bComment) {
-			line = bytes.TrimLeft(line, "#")
-			line = bytes.TrimLeftFunc(line, unicode.IsSpace)
-			comment.Write(line)
-			comment.WriteByte('\n')
-			continue
+func (c *Config) keys() []string {
+	keys := make([]string, 0, len(c.data))
+	for _, line := range c.lines {
+		if line.kind == assignLine {
+			keys = append(keys, line.key)
 		}
-		if comment.Len() != 0 {
-			cfg.comment[nComment] = []string{comment.String()}
-			comment.Reset()
-			nComment++
+	}
+	for _, line := range c.externals {
+		if c.find(line.key) == nil {
+			keys = append(keys, line.key)
 		}
+	}
+	return keys
+}
 
-		val := bytes.SplitN(line, bEqual, 2)
-		if bytes.HasPrefix(val[1], bDQuote) {
-			val[1] = bytes.Trim(val[1], `"`)
-		}
+// value returns the value for a given key, or its default value in the
+// schema, or a *KeyNotFoundError.
+func (c *Config) value(key string) (string, error) {
+	c.RLock()
+	defer c.RUnlock()
 
-		key := string(val[0])
-		cfg.comment[nComment-1] = append(cfg.comment[nComment-1], key)
-		cfg.data[key] = string(val[1])
-		cfg.offset[key] = off
+	v, found := c.data[key]
+	if !found {
+		if c.schema != nil {
+			if r, found := c.schema.index[key]; found && r.Default != "" {
+				return r.Default, nil
+			}
+		}
+		return "", &KeyNotFoundError{key}
 	}
-	return cfg, nil
+	return v, nil
 }
 
 // Bool returns the boolean value for a given key.
 func (c *Config) Bool(key string) (bool, error) {
-	return strconv.ParseBool(c.data[key])
+	v, err := c.value(key)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(v)
 }
 
 // Int returns the integer value for a given key.
 func (c *Config) Int(key string) (int, error) {
-	return strconv.Atoi(c.data[key])
+	v, err := c.value(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// Int64 returns the 64-bit integer value for a given key.
+func (c *Config) Int64(key string) (int64, error) {
+	v, err := c.value(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// Uint returns the unsigned integer value for a given key.
+func (c *Config) Uint(key string) (uint, error) {
+	v, err := c.value(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(v, 10, 0)
+	return uint(n), err
 }
 
 // Float returns the float value for a given key.
 func (c *Config) Float(key string) (float64, error) {
-	return strconv.ParseFloat(c.data[key], 64)
+	v, err := c.value(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// Duration returns the duration value for a given key, in the format of
+// time.ParseDuration, as "1m30s".
+func (c *Config) Duration(key string) (time.Duration, error) {
+	v, err := c.value(key)
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(v)
+}
+
+// Time returns the time value for a given key, in the format given by layout;
+// see time.Parse.
+func (c *Config) Time(key, layout string) (time.Time, error) {
+	v, err := c.value(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(layout, v)
 }
 
-// String returns the string value for a given key.
+// String returns the string value for a given key, already unquoted.
+// It returns an empty string if the key is not found; use Has or StringOr
+// to know whether it exists.
 func (c *Config) String(key string) string {
-	return c.data[key]
+	v, _ := c.value(key)
+	return v
+}
+
+// List returns the value for a given key split in elements separated by
+// commas, blanks or both, as "a, b c".
+func (c *Config) List(key string) ([]string, error) {
+	v, err := c.value(key)
+	if err != nil {
+		return nil, err
+	}
+	return splitList(v, ""), nil
 }
 
-// WriteValue writes a new value for key.
+// == Getters with a default value, returned when the key is not found.
+
+// BoolOr returns the boolean value for a given key, or def if it is not found.
+func (c *Config) BoolOr(key string, def bool) (bool, error) {
+	v, err := c.Bool(key)
+	if isNotFound(err) {
+		return def, nil
+	}
+	return v, err
+}
+
+// IntOr returns the integer value for a given key, or def if it is not found.
+func (c *Config) IntOr(key string, def int) (int, error) {
+	v, err := c.Int(key)
+	if isNotFound(err) {
+		return def, nil
+	}
+	return v, err
+}
+
+// Int64Or returns the 64-bit integer value for a given key, or def if it is
+// not found.
+func (c *Config) Int64Or(key string, def int64) (int64, error) {
+	v, err := c.Int64(key)
+	if isNotFound(err) {
+		return def, nil
+	}
+	return v, err
+}
+
+// UintOr returns the unsigned integer value for a given key, or def if it is
+// not found.
+func (c *Config) UintOr(key string, def uint) (uint, error) {
+	v, err := c.Uint(key)
+	if isNotFound(err) {
+		return def, nil
+	}
+	return v, err
+}
+
+// FloatOr returns the float value for a given key, or def if it is not found.
+func (c *Config) FloatOr(key string, def float64) (float64, error) {
+	v, err := c.Float(key)
+	if isNotFound(err) {
+		return def, nil
+	}
+	return v, err
+}
+
+// DurationOr returns the duration value for a given key, or def if it is not
+// found.
+func (c *Config) DurationOr(key string, def time.Duration) (time.Duration, error) {
+	v, err := c.Duration(key)
+	if isNotFound(err) {
+		return def, nil
+	}
+	return v, err
+}
+
+// StringOr returns the string value for a given key, or def if it is not
+// found.
+func (c *Config) StringOr(key, def string) string {
+	v, err := c.value(key)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// isNotFound reports whether err is a *KeyNotFoundError.
+func isNotFound(err error) bool {
+	_, ok := err.(*KeyNotFoundError)
+	return ok
+}
+
+// ErrNoFile is returned when a Config which was not parsed from a file has to
+// be written; use SetValue and WriteTo instead.
+var ErrNoFile = errors.New("configuration is not backed by a file")
+
+// ErrClosed is returned when a Config is edited or written after of Close,
+// since its secret values have been removed.
+var ErrClosed = errors.New("configuration is closed")
+
+// WriteValue writes a new value for key in the file.
+// The rest of the file is kept such as it is.
+//
+// The file is replaced atomically: the new content is written to a temporary
+// file in the same directory, which is renamed over the original one, so the
+// file is never left truncated. Its mode, owner and SELinux context are kept.
+// The file is locked while it is written; if it has been changed by other
+// process since it was read, a *ConflictError is returned, unless the mode
+// set with SetConflict is MergeOnConflict.
 func (c *Config) WriteValue(key, value string) error {
 	c.Lock()
 	defer c.Unlock()
 
-	if _, found := c.data[key]; !found {
-		return errors.New("key not found: " + key)
+	if c.closed {
+		return ErrClosed
+	}
+	if c.filename == "" {
+		return ErrNoFile
+	}
+	if err := c.checkLocal(key); err != nil {
+		return err
 	}
 
-	replAt := []file.ReplacerAtLine{
-		{key + "=", "=.*", "=" + value},
+	line := c.find(key)
+	if line == nil {
+		return &KeyNotFoundError{key}
+	}
+	if line.array != noArray {
+		return errArray(key)
 	}
+	old := *line
+	line.setValue(value)
 
-	if err := file.ReplaceAtLine(c.filename, replAt); err != nil {
+	if err := c.writeFile(); err != nil {
+		*line = old
 		return err
 	}
 	c.data[key] = value
@@ -12,7 +12,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var testdata = []struct {
@@ -85,3 +87,260 @@ _exit:
 		t.Fatal(err)
 	}
 }
+
+var quotedata = []struct {
+	in   string
+	want string
+}{
+	{`A=plain`, "plain"},
+	{`A=`, ""},
+	{`A="double quoted"`, "double quoted"},
+	{`A='single "quoted"'`, `single "quoted"`},
+	{`A="escaped \" \$ \\ \n"`, `escaped " $ \ \n`},
+	{`A=out\ of\ \"quotes\"`, `out of "quotes"`},
+	{`A=mix"ed "'quo tes'`, "mixed quo tes"},
+	{`A='it'\''s'`, "it's"},
+	{`A=a#b`, "a#b"},
+	{`A=value # comment`, "value"},
+	{`A=${B:-a b}`, "${B:-a b}"},
+	{"export A=exported", "exported"},
+	{"A=\"multi\nline\"", "multi\nline"},
+	{"A='multi\nline'", "multi\nline"},
+	{"A=contin\\\nued", "continued"},
+	{"A=\"contin\\\nued\"", "continued"},
+}
+
+func TestQuoting(t *testing.T) {
+	for _, tt := range quotedata {
+		l, err := NewScanner(strings.NewReader(tt.in + "\n")).next()
+		if err != nil {
+			t.Errorf("%q: got error: %s", tt.in, err)
+			continue
+		}
+		if l.value != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, l.value, tt.want)
+		}
+
+		l, err = NewScanner(strings.NewReader("A=" + Quote(tt.want))).next()
+		if err != nil || l.value != tt.want {
+			t.Errorf("Quote(%q) does not round-trip: got %q (%v)", tt.want, l.value, err)
+		}
+	}
+
+	for _, in := range []string{
+		`A="unterminated`,
+		`A='unterminated`,
+		`A=two words`,
+		`A=a;b`,
+		`no equal`,
+	} {
+		if _, err := NewScanner(strings.NewReader(in + "\n")).next(); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+var expanddata = []struct {
+	in   string
+	key  string
+	want string
+}{
+	{"BASE=/opt\nLOG=$BASE/log", "LOG", "/opt/log"},
+	{"BASE=/opt\nLOG=\"${BASE}/log dir\"", "LOG", "/opt/log dir"},
+	{"BASE=/opt\nLOG='$BASE/log'", "LOG", "$BASE/log"},
+	{"BASE=/opt\nLOG=\\$BASE", "LOG", "$BASE"},
+	{"LOG=${BASE:-/var}/log", "LOG", "/var/log"},
+	{"BASE=\nLOG=${BASE-/var}/log", "LOG", "/log"},
+	{"BASE=/opt\nLOG=${BASE:+set}", "LOG", "set"},
+	{"A=1\nB=${A}2\nC=$B", "C", "12"},
+	{"LOG=$BASE/log\nBASE=/opt", "LOG", "/log"},
+	{"LOG=$HOME_TEST/log\nHOME_TEST=/opt", "LOG", "/home/test/log"},
+	{"A=$B\nB=$A", "B", ""},
+	{"LOG=$HOME_TEST/log", "LOG", "/home/test/log"},
+	{"HOME_TEST=/opt\nLOG=$HOME_TEST/log", "LOG", "/opt/log"},
+	{"A=${B=x}\nC=${B-unset}", "C", "x"},
+	{"A=${B=x}\nC=${B-unset}", "A", "x"},
+	{"A=${B=x}$B", "A", "xx"},
+	{"B=\nA=${B=x}\nC=${B-unset}", "C", ""},
+	{"B=\nA=${B:=x}\nC=${B-unset}", "C", "x"},
+	{"A=${HOME_TEST=x}\nC=$HOME_TEST", "C", "/home/test"},
+	{"C=${B-unset}\nA=${B=x}", "C", "unset"},
+	{"A=${B=x}\nB=y\nC=$B", "C", "y"},
+	{"A=${B=x}\nC=(${B-unset} z)", "C", "x z"},
+}
+
+func TestExpand(t *testing.T) {
+	p := &Parser{
+		Mode: ExpandEnv,
+		LookupEnv: func(key string) (string, bool) {
+			if key == "HOME_TEST" {
+				return "/home/test", true
+			}
+			return "", false
+		},
+	}
+
+	for _, tt := range expanddata {
+		cfg, err := p.ParseFile(tempFile(t, tt.in))
+		if err != nil {
+			t.Errorf("%q: got error: %s", tt.in, err)
+			continue
+		}
+		if got := cfg.String(tt.key); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"A=${B:?is required}",
+		"A=$(date)",
+		"A=$1",
+	} {
+		if _, err := p.ParseFile(tempFile(t, in)); err == nil {
+			t.Errorf("%q: expected error", in)
+		} else if !strings.Contains(err.Error(), "key A") {
+			t.Errorf("%q: error does not name the key: %s", in, err)
+		}
+	}
+}
+
+// tempFile writes content to a temporary file, which is removed at finishing
+// the test, and returns its name.
+func tempFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := file.Name()
+	t.Cleanup(func() { os.Remove(name) })
+
+	if _, err = file.WriteString(content + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	return name
+}
+
+func TestParseString(t *testing.T) {
+	cfg, err := ParseString("# main comment\n\nA=1\nB='two words'\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cfg.Int("A"); got != 1 {
+		t.Errorf("A: got %d, want %d", got, 1)
+	}
+	if got := cfg.String("B"); got != "two words" {
+		t.Errorf("B: got %q, want %q", got, "two words")
+	}
+	if err = cfg.WriteValue("A", "2"); err != ErrNoFile {
+		t.Errorf("WriteValue: got error %v, want %v", err, ErrNoFile)
+	}
+
+	if _, err = ParseBytes([]byte("A='unterminated\n")); err == nil ||
+		!strings.HasPrefix(err.Error(), "line 1:") {
+		t.Errorf("ParseBytes: expected error at line 1, got %v", err)
+	}
+}
+
+var parseErrors = []struct {
+	in     string
+	line   int
+	column int
+}{
+	{"A=1\nno equal\n", 2, 3},
+	{"A=1\n1A=2\n", 2, 1},
+	{"A=1\n  -A=2\n", 2, 3},
+	{"A=1\nB=2\nA=3\n", 3, 1},
+	{"A=1\nB='open\nC=3\n", 2, 3},
+	{"A=1\nB=\"x\ny\" z\n", 3, 4},
+	{"A=\"x\ny${B:?unset}\"\n", 2, 2},
+}
+
+func TestParseError(t *testing.T) {
+	p := &Parser{Mode: Expand}
+
+	for _, tt := range parseErrors {
+		_, err := p.Parse(strings.NewReader(tt.in))
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%q: expected *ParseError, got %v", tt.in, err)
+			continue
+		}
+		if e.Line != tt.line || e.Column != tt.column {
+			t.Errorf("%q: got position %d:%d, want %d:%d (%s)",
+				tt.in, e.Line, e.Column, tt.line, tt.column, e)
+		}
+	}
+
+	_, err := (&Parser{Mode: Strict}).Parse(strings.NewReader(
+		"A=1\nno equal\nA=2\nB=$(date)\nC=ok\n"))
+	if list, ok := err.(ErrorList); !ok || len(list) != 2 {
+		t.Errorf("Strict: expected 2 errors, got %v", err)
+	}
+	_, err = (&Parser{Mode: Strict | Expand}).Parse(strings.NewReader(
+		"A=1\nno equal\nA=2\nB=$(date)\nC=ok\n"))
+	if list, ok := err.(ErrorList); !ok || len(list) != 3 {
+		t.Errorf("Strict|Expand: expected 3 errors, got %v", err)
+	} else if list[2].Line != 4 {
+		t.Errorf("Strict|Expand: got error at line %d, want %d", list[2].Line, 4)
+	}
+}
+
+func TestGetters(t *testing.T) {
+	cfg, err := ParseString(`
+Z=last
+Int64=-9000000000
+Uint=7
+Duration=1h
+Time=2012-12-21
+List="a, b  c"
+Bad=x
+A=first`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := cfg.Keys()
+	if len(keys) != 8 || keys[0] != "Z" || keys[7] != "A" {
+		t.Errorf("Keys got %v", keys)
+	}
+	if !cfg.Has("Z") || cfg.Has("Missing") {
+		t.Error("Has got a wrong result")
+	}
+
+	if v, err := cfg.Int64("Int64"); err != nil || v != -9000000000 {
+		t.Errorf("Int64 got %v, %v", v, err)
+	}
+	if v, err := cfg.Uint("Uint"); err != nil || v != 7 {
+		t.Errorf("Uint got %v, %v", v, err)
+	}
+	if v, err := cfg.Duration("Duration"); err != nil || v != time.Hour {
+		t.Errorf("Duration got %v, %v", v, err)
+	}
+	if v, err := cfg.Time("Time", "2006-01-02"); err != nil || v.Day() != 21 {
+		t.Errorf("Time got %v, %v", v, err)
+	}
+	if v, err := cfg.List("List"); err != nil || len(v) != 3 || v[2] != "c" {
+		t.Errorf("List got %q, %v", v, err)
+	}
+
+	// Missing keys and malformed values.
+	if _, err = cfg.Int("Missing"); !isNotFound(err) {
+		t.Errorf("Int got error %v, want KeyNotFoundError", err)
+	}
+	if _, err = cfg.Int("Bad"); err == nil || isNotFound(err) {
+		t.Errorf("Int got error %v, want a syntax error", err)
+	}
+	if v, err := cfg.IntOr("Missing", 5); err != nil || v != 5 {
+		t.Errorf("IntOr got %v, %v", v, err)
+	}
+	if _, err := cfg.BoolOr("Bad", true); err == nil {
+		t.Error("BoolOr: expected error for a malformed value")
+	}
+	if v := cfg.StringOr("Missing", "def"); v != "def" {
+		t.Errorf("StringOr got %q", v)
+	}
+	if v, _ := cfg.DurationOr("Duration", time.Second); v != time.Hour {
+		t.Errorf("DurationOr got %v", v)
+	}
+}
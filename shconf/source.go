@@ -0,0 +1,209 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A configuration can be split in several files, with the mode Source for
+// the files included with ". FILE" or "source FILE", and with the mode DropIn
+// for the files in the directory "FILE.d". The files are read in order, such
+// as the shell would do; a key can be set again in other file, and the last
+// value wins. Only the lines of the main file are edited and written.
+
+// A Position is the place where a key is set.
+type Position struct {
+	Filename string // empty if the configuration is not backed by a file
+	Line     int
+}
+
+func (p Position) String() string {
+	if p.Filename == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+	return fmt.Sprintf("%s:%d", p.Filename, p.Line)
+}
+
+// Origin returns the position of the assignment which sets the value of key.
+func (c *Config) Origin(key string) (pos Position, found bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	line := c.external(key)
+	if line == nil {
+		if line = c.find(key); line == nil {
+			return Position{}, false
+		}
+	}
+	return Position{line.file, line.line}, true
+}
+
+// external returns the assignment of key in other file than the main one,
+// which sets its value, or nil.
+func (c *Config) external(key string) *logicalLine {
+	for _, line := range c.externals {
+		if line.key == key {
+			return line
+		}
+	}
+	return nil
+}
+
+// checkLocal checks that the value of key is set in the main file, so it can
+// be edited.
+func (c *Config) checkLocal(key string) error {
+	if line := c.external(key); line != nil {
+		return fmt.Errorf("key %s is set in %s", key, Position{line.file, line.line})
+	}
+	return nil
+}
+
+// parseState holds the state of a parsing through several files.
+type parseState struct {
+	p       *Parser
+	assigns []*logicalLine // assignments of all files, in order
+	errs    ErrorList
+	files   []string // absolute names of the files being read
+	read    []string // names of all the files read, in order
+}
+
+// readFile reads from r the lines of the named file, and it reads the files
+// included from it in Source mode. It returns the lines of the file.
+func (st *parseState) readFile(r io.Reader, filename string) ([]*logicalLine, error) {
+	if filename != "" {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return nil, err
+		}
+		st.files = append(st.files, abs)
+		defer func() { st.files = st.files[:len(st.files)-1] }()
+		st.read = append(st.read, filename)
+	}
+
+	var lines []*logicalLine
+	defined := make(map[string]*logicalLine)
+	secretNote := false // whether the comment above has the note of secret
+
+	scan := NewScanner(r)
+	scan.filename = filename
+	scan.keep = true
+
+	for {
+		line, err := scan.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err = st.error(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		line.file = filename
+		lines = append(lines, line)
+
+		switch line.kind {
+		case sourceLine:
+			if st.p.Mode&Source != 0 {
+				if err = st.source(line); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case commentLine:
+			secretNote = secretNote || hasSecretNote(line.comment)
+			continue
+		case blankLine:
+			secretNote = false
+			continue
+		}
+
+		line.secret = secretNote || hasSecretNote(line.comment) || st.p.isSecret(line.key)
+		secretNote = false
+
+		if first, found := defined[line.key]; found {
+			err = line.error(filename, len(line.raw), fmt.Sprintf(
+				"duplicate key %s, first defined at line %d", line.key, first.line))
+			if err = st.error(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		defined[line.key] = line
+		st.assigns = append(st.assigns, line)
+	}
+	return lines, nil
+}
+
+// source reads the file included by line. A relative name is resolved from
+// the directory of the file which includes it.
+func (st *parseState) source(line *logicalLine) error {
+	name := line.value
+	if !filepath.IsAbs(name) && line.file != "" {
+		name = filepath.Join(filepath.Dir(line.file), name)
+	}
+
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	for _, f := range st.files {
+		if f == abs {
+			return st.error(line.error(line.file, len(line.raw),
+				"loop in inclusion of file "+line.value))
+		}
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return st.error(line.error(line.file, len(line.raw), err.Error()))
+	}
+	defer file.Close()
+
+	_, err = st.readFile(file, name)
+	return err
+}
+
+// readDropIns reads the files with extension ".conf" in the directory dir,
+// in lexical order. It is not an error if the directory does not exist.
+func (st *parseState) readDropIns(dir string) error {
+	// The names returned by Glob are sorted.
+	names, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if info, err := os.Stat(name); err != nil || info.IsDir() {
+			continue
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		_, err = st.readFile(file, name)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// error handles an error found at parsing. It returns nil if the error has
+// been added to the list of errors, in Strict mode.
+func (st *parseState) error(err error) error {
+	if e, ok := err.(*ParseError); ok && st.p.Mode&Strict != 0 {
+		st.errs = append(st.errs, e)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main":           "A=1\n. common # shared keys\nB=$C\nsource sub/extra\n",
+		"common":         "C=2\nD=common\n",
+		"sub/extra":      "E=3\n. ../last\n",
+		"last":           "D=last\n",
+		"main.d/20.conf": "A=20\nF=${A}0\n",
+		"main.d/10.conf": "A=10\n",
+		"main.d/skip":    "A=skip\n",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	main := filepath.Join(dir, "main")
+
+	// Without the modes, the directives are kept but not followed.
+	cfg, err := ParseFile(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := cfg.Keys(); !reflect.DeepEqual(keys, []string{"A", "B"}) {
+		t.Errorf("Keys got %v, want [A B]", keys)
+	}
+
+	p := &Parser{Mode: Expand | Source | DropIn}
+	if cfg, err = p.ParseFile(main); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"A": "20", "B": "2", "C": "2", "D": "last", "E": "3", "F": "200"}
+	if !reflect.DeepEqual(cfg.data, want) {
+		t.Errorf("data got %v, want %v", cfg.data, want)
+	}
+	if keys := cfg.Keys(); !reflect.DeepEqual(keys, []string{"A", "B", "C", "E", "D", "F"}) {
+		t.Errorf("Keys got %v", keys)
+	}
+
+	for _, tt := range []struct {
+		key  string
+		file string
+		line int
+	}{
+		{"B", "main", 3},
+		{"C", "common", 1},
+		{"D", "last", 1},
+		{"E", "sub/extra", 1},
+		{"A", "main.d/20.conf", 1},
+	} {
+		pos, found := cfg.Origin(tt.key)
+		if !found {
+			t.Errorf("Origin(%s) not found", tt.key)
+			continue
+		}
+		// The included files are named from the directory of the main file.
+		if !strings.HasSuffix(filepath.ToSlash(pos.Filename), tt.file) || pos.Line != tt.line {
+			t.Errorf("Origin(%s) got %v, want %s:%d", tt.key, pos, tt.file, tt.line)
+		}
+	}
+	if _, found := cfg.Origin("X"); found {
+		t.Error("Origin(X) found")
+	}
+
+	// Only the values set in the main file can be edited.
+	if err = cfg.SetValue("B", "x"); err != nil {
+		t.Error(err)
+	}
+	if err = cfg.SetValue("A", "x"); err == nil || !strings.Contains(err.Error(), "20.conf:1") {
+		t.Errorf("SetValue(A) got error %v", err)
+	}
+	if err = cfg.DeleteKey("C"); err == nil {
+		t.Error("DeleteKey(C) expected error")
+	}
+
+	var b bytes.Buffer
+	cfg.WriteTo(&b)
+	if got := b.String(); got != "A=1\n. common # shared keys\nB=x\nsource sub/extra\n" {
+		t.Errorf("WriteTo got %q", got)
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		name = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	write("a", "A=1\n. b\n")
+	write("b", "B=1\n. a\n")
+	write("dup", "A=2\nA=3\n")
+
+	p := &Parser{Mode: Source}
+	for _, tt := range []struct {
+		content string
+		err     string
+	}{
+		{". a", "b:2:1: loop in inclusion of file a"},
+		{". missing", "main:1:1: open "},
+		{". dup", "dup:2:1: duplicate key A, first defined at line 1"},
+		{". $HOME/x", "main:1:3: expansion in name of file is not supported: $HOME/x"},
+		{"source a b", "main:1:10: unexpected text after name of file: b"},
+	} {
+		_, err := p.ParseFile(write("main", tt.content))
+		if err == nil {
+			t.Errorf("%q: expected error", tt.content)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q got error %q, want %q", tt.content, err, tt.err)
+		}
+	}
+
+	// A key can be set again in other file.
+	cfg, err := p.ParseFile(write("main", "A=1\n. dup2\n"))
+	if err == nil {
+		t.Errorf("expected error for missing file, got %v", cfg.data)
+	}
+	write("dup2", "A=2\n")
+	if cfg, err = p.ParseFile(filepath.Join(dir, "main")); err != nil {
+		t.Fatal(err)
+	}
+	if v := cfg.String("A"); v != "2" {
+		t.Errorf("A got %q, want 2", v)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build linux
+// +build linux
+
+package shconf
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchFile calls notify every time the named file changes, until stop is
+// called. It watches the directory so the file can be replaced by rename, as
+// it is done by the editors and by WriteValue.
+//
+// It uses inotify, and it falls back to polling if it is not available.
+func watchFile(name string, notify func()) (stop func() error, err error) {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	stop, err = inotify(dir, func(s string) bool { return s == base }, notify)
+	if err == errNoInotify {
+		return pollFile(name, notify), nil
+	}
+	return stop, err
+}
+
+// watchDir calls notify every time a file with extension ".conf" is added,
+// changed or removed in the directory dir, until stop is called. It polls the
+// directory if it does not exist, to know when it is created.
+func watchDir(dir string, notify func()) (stop func() error, err error) {
+	if _, err = os.Stat(dir); err != nil {
+		return pollFile(dir, notify), nil
+	}
+	stop, err = inotify(dir, func(s string) bool { return strings.HasSuffix(s, ".conf") }, notify)
+	if err == errNoInotify {
+		return pollFile(dir, notify), nil
+	}
+	return stop, err
+}
+
+var errNoInotify = errors.New("inotify is not available")
+
+// inotify calls notify every time an entry of the directory dir, whose name
+// matches, changes. It returns errNoInotify if inotify is not available.
+func inotify(dir string, match func(string) bool, notify func()) (stop func() error, err error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, errNoInotify
+	}
+	// The file is non-blocking so Close unblocks the reading.
+	file := os.NewFile(uintptr(fd), "inotify")
+
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_MOVED_TO |
+		syscall.IN_MOVED_FROM | syscall.IN_CREATE | syscall.IN_DELETE
+
+	if _, err = syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		file.Close()
+		return nil, &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+
+	go func() {
+		buf := make([]byte, 16*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				off += syscall.SizeofInotifyEvent
+				evName := buf[off : off+int(ev.Len)]
+				off += int(ev.Len)
+
+				if match(string(bytes.TrimRight(evName, "\x00"))) {
+					notify()
+				}
+			}
+		}
+	}()
+
+	return file.Close, nil
+}
@@ -0,0 +1,22 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !linux
+// +build !linux
+
+package shconf
+
+// watchFile calls notify every time the named file changes, until stop is
+// called.
+func watchFile(name string, notify func()) (stop func() error, err error) {
+	return pollFile(name, notify), nil
+}
+
+// watchDir calls notify every time a file is added or removed in the
+// directory dir, until stop is called.
+func watchDir(dir string, notify func()) (stop func() error, err error) {
+	return pollFile(dir, notify), nil
+}
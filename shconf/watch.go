@@ -0,0 +1,333 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// DefaultReloadDelay is the time to wait after of a change in the file before
+// of reloading it, when no delay is given to Watch.
+const DefaultReloadDelay = 100 * time.Millisecond
+
+// pollInterval is the interval to check the file when the system has no
+// notification of changes in files.
+var pollInterval = time.Second
+
+// A Diff holds the keys changed at reloading a configuration, in the order of
+// the file.
+type Diff struct {
+	Added   []string
+	Removed []string // in the order of the old file
+	Changed []string
+}
+
+// IsEmpty reports whether there are no changes.
+func (d Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// A watcher holds the state of a watched file.
+type watcher struct {
+	events   chan struct{} // notification of a change
+	done     chan struct{}
+	stop     func() error // stops the notification
+	onChange []func(Diff)
+	onError  []func(error)
+}
+
+// notify sends a notification of a change, without blocking.
+func (w *watcher) notify() {
+	select {
+	case w.events <- struct{}{}:
+	default:
+	}
+}
+
+// Watch watches the files of the configuration, reloading it when any of
+// them changes; the reload is done when the files have not been changed during
+// delay, or DefaultReloadDelay if it is zero. They are the main file, the files
+// included in Source mode, and the drop-in directory in DropIn mode, where a
+// file can be added or removed; the files included after of a reload are
+// watched too.
+//
+// The file is parsed with the same mode used at the beginning. At reloading,
+// the values are replaced and the functions given to OnChange are called with
+// the keys changed; if the new file can not be parsed, the values are kept
+// and the functions given to OnError are called.
+//
+// Linux uses inotify, and other systems poll the files every second. A closed
+// Config can not be watched.
+func (c *Config) Watch(delay time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if c.filename == "" {
+		return ErrNoFile
+	}
+	if c.watch != nil && c.watch.stop != nil {
+		return errors.New("configuration already watched")
+	}
+	if delay <= 0 {
+		delay = DefaultReloadDelay
+	}
+
+	w := c.watcher()
+	w.events = make(chan struct{}, 1)
+	w.done = make(chan struct{})
+
+	stop, err := c.watchFiles(c.files, w.notify)
+	if err != nil {
+		return err
+	}
+	w.stop = stop
+
+	go c.watchLoop(w, delay)
+	return nil
+}
+
+// OnChange adds a function to call when the configuration is reloaded with
+// changes in its keys.
+func (c *Config) OnChange(fn func(Diff)) {
+	c.Lock()
+	w := c.watcher()
+	w.onChange = append(w.onChange, fn)
+	c.Unlock()
+}
+
+// OnError adds a function to call when the configuration can not be
+// reloaded.
+func (c *Config) OnError(fn func(error)) {
+	c.Lock()
+	w := c.watcher()
+	w.onError = append(w.onError, fn)
+	c.Unlock()
+}
+
+// Close stops watching the file, and removes the secret values. The Config can
+// still be read, but the methods which edit or write it return ErrClosed.
+func (c *Config) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.closed = true
+	c.zeroSecrets()
+	w := c.watch
+	if w == nil || w.stop == nil {
+		return nil
+	}
+	close(w.done)
+	err := w.stop()
+	w.stop = nil
+	return err
+}
+
+// watchFiles watches the files, and the drop-in directory in DropIn mode. It
+// returns the function to stop watching all of them.
+func (c *Config) watchFiles(files []string, notify func()) (stop func() error, err error) {
+	if len(files) == 0 {
+		files = []string{c.filename}
+	}
+
+	var stops []func() error
+	stop = func() error {
+		var err error
+		for _, fn := range stops {
+			if e := fn(); e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	}
+
+	for _, name := range files {
+		fn, err := watchFile(name, notify)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		stops = append(stops, fn)
+	}
+	if c.parser.Mode&DropIn != 0 {
+		fn, err := watchDir(c.filename+".d", notify)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		stops = append(stops, fn)
+	}
+	return stop, nil
+}
+
+// watcher returns the watcher, creating it if it is needed.
+func (c *Config) watcher() *watcher {
+	if c.watch == nil {
+		c.watch = new(watcher)
+	}
+	return c.watch
+}
+
+// watchLoop reloads the configuration after of the changes of the file.
+func (c *Config) watchLoop(w *watcher, delay time.Duration) {
+	var timer <-chan time.Time
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.events:
+			timer = time.After(delay)
+		case <-timer:
+			timer = nil
+			c.reload()
+		}
+	}
+}
+
+// reload parses the file again, and replaces the values if it is right. It
+// does nothing after of closing the Config, so the secret values are not read
+// again.
+func (c *Config) reload() {
+	c.RLock()
+	p, filename, closed := c.parser, c.filename, c.closed
+	c.RUnlock()
+	if closed {
+		return
+	}
+
+	cfg, err := p.ParseFile(filename)
+
+	c.Lock()
+	if c.closed { // closed during the parsing
+		c.Unlock()
+		return
+	}
+	var diff Diff
+	var watchErr error
+	if err == nil {
+		diff = diffData(c.keys(), c.data, cfg.keys(), cfg.data)
+		c.data, c.lines, c.comment = cfg.data, cfg.lines, cfg.comment
+		c.externals, c.secret = cfg.externals, cfg.secret
+		c.stamp, c.orig = cfg.stamp, cfg.orig
+
+		if !equalStrings(c.files, cfg.files) {
+			watchErr = c.rewatch(cfg.files)
+		}
+	}
+	onChange := c.watch.onChange
+	onError := c.watch.onError
+	c.Unlock()
+
+	if err == nil {
+		err = watchErr
+	}
+	if err != nil {
+		for _, fn := range onError {
+			fn(err)
+		}
+	}
+	if !diff.IsEmpty() {
+		for _, fn := range onChange {
+			fn(diff)
+		}
+	}
+}
+
+// rewatch watches the files read at a reload, instead of the ones read before.
+// If they can not be watched, the old ones are still watched, and it is tried
+// again at the next reload. The caller must hold the lock of c.
+func (c *Config) rewatch(files []string) error {
+	w := c.watch
+	if w.stop != nil {
+		stop, err := c.watchFiles(files, w.notify)
+		if err != nil {
+			return err
+		}
+		w.stop()
+		w.stop = stop
+	}
+	c.files = files
+	return nil
+}
+
+// equalStrings reports whether a and b have the same strings, in order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffData returns the keys changed from the old keys and values to the new
+// ones.
+func diffData(oldKeys []string, oldData map[string]string,
+	newKeys []string, newData map[string]string) Diff {
+
+	var d Diff
+	for _, key := range newKeys {
+		old, found := oldData[key]
+		switch {
+		case !found:
+			d.Added = append(d.Added, key)
+		case old != newData[key]:
+			d.Changed = append(d.Changed, key)
+		}
+	}
+	for _, key := range oldKeys {
+		if _, found := newData[key]; !found {
+			d.Removed = append(d.Removed, key)
+		}
+	}
+	return d
+}
+
+// pollFile calls notify every time the modification time or the size of the
+// named file change, until stop is called.
+func pollFile(name string, notify func()) (stop func() error) {
+	done := make(chan struct{})
+	last, _ := os.Stat(name)
+
+	go func() {
+		tick := time.NewTicker(pollInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+			}
+
+			info, err := os.Stat(name)
+			switch {
+			case err != nil && last == nil:
+			case err != nil || last == nil,
+				!info.ModTime().Equal(last.ModTime()), info.Size() != last.Size():
+				notify()
+			}
+			if err != nil {
+				info = nil
+			}
+			last = info
+		}
+	}()
+
+	return func() error {
+		close(done)
+		return nil
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "default")
+	replace := func(content string) {
+		tmp := name + ".new"
+		if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replace("A=1\nB=2\nC=3\n")
+
+	cfg, err := ParseFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Close()
+
+	changes := make(chan Diff, 1)
+	errs := make(chan error, 1)
+	cfg.OnChange(func(d Diff) { changes <- d })
+	cfg.OnError(func(err error) { errs <- err })
+
+	if err = cfg.Watch(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	replace("A=1\nC=30\nD=4\n")
+	select {
+	case d := <-changes:
+		want := Diff{Added: []string{"D"}, Removed: []string{"B"}, Changed: []string{"C"}}
+		if !reflect.DeepEqual(d, want) {
+			t.Errorf("got diff %+v, want %+v", d, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload")
+	}
+	if v := cfg.String("C"); v != "30" {
+		t.Errorf("C got %q, want %q", v, "30")
+	}
+
+	replace("A='broken\n")
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error at reloading")
+	}
+	if v := cfg.String("D"); v != "4" {
+		t.Errorf("D got %q, want %q after of a failed reload", v, "4")
+	}
+}
+
+func TestWatchSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		name = filepath.Join(dir, name)
+		tmp := name + ".new"
+		if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("default", ". inc\nA=1\n")
+	write("inc", "B=2\n")
+	write("inc2", "")
+	if err = os.Mkdir(filepath.Join(dir, "default.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := (&Parser{Mode: Source | DropIn}).ParseFile(filepath.Join(dir, "default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Close()
+
+	changes := make(chan Diff, 1)
+	cfg.OnChange(func(d Diff) { changes <- d })
+	cfg.OnError(func(err error) { t.Error(err) })
+	if err = cfg.Watch(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, content string
+		want          Diff
+	}{
+		{"inc", "B=20\n", Diff{Changed: []string{"B"}}},
+		{"default.d/a.conf", "C=3\n", Diff{Added: []string{"C"}}},
+		{"default", ". inc\n. inc2\nA=1\n", Diff{}}, // inc2 is empty
+		{"inc2", "D=4\n", Diff{Added: []string{"D"}}},
+	} {
+		write(tt.name, tt.content)
+		if tt.want.IsEmpty() {
+			time.Sleep(100 * time.Millisecond) // the reload without changes
+			continue
+		}
+		select {
+		case d := <-changes:
+			if !reflect.DeepEqual(d, tt.want) {
+				t.Errorf("%s: got diff %+v, want %+v", tt.name, d, tt.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no reload", tt.name)
+		}
+	}
+}
+
+func TestPollFile(t *testing.T) {
+	name := tempFile(t, "A=1")
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = time.Second }()
+
+	changes := make(chan struct{}, 1)
+	stop := pollFile(name, func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	})
+	defer stop()
+
+	if err := ioutil.WriteFile(name, []byte("A=10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change detected")
+	}
+}
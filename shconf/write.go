@@ -0,0 +1,203 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A BackupMode indicates whether a backup of the file is made before of
+// writing it.
+type BackupMode int
+
+const (
+	NoBackup       BackupMode = iota
+	SimpleBackup              // a copy in file.bak, replaced at every writing
+	NumberedBackup            // a new copy at every writing: file.1, file.2, ...
+)
+
+// SetBackup sets the backup to make before of writing the file.
+func (c *Config) SetBackup(mode BackupMode) {
+	c.Lock()
+	c.backup = mode
+	c.Unlock()
+}
+
+// Save writes the configuration to its file, such as WriteValue does, after
+// of editing it with methods such as AddKey or DeleteKey.
+func (c *Config) Save() error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if c.filename == "" {
+		return ErrNoFile
+	}
+	return c.writeFile()
+}
+
+// writeFile replaces the file by the lines of the configuration, safely: they
+// are written to a temporary file which is synced to disk and renamed to the
+// file name, and then the directory is synced.
+//
+// The file is locked while it is written, and it is checked that it has not
+// been changed since it was read; else, the changes are merged in the mode
+// MergeOnConflict, or a *ConflictError is returned.
+func (c *Config) writeFile() error {
+	// Replace the file pointed by a symbolic link, not the link.
+	name, err := filepath.EvalSymlinks(c.filename)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockFile(name)
+	if err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	changed, err := c.stamp.changed(name)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if c.conflict != MergeOnConflict {
+			return &ConflictError{Filename: c.filename}
+		}
+		if err = c.merge(); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	h := sha256.New()
+	if _, err = c.writeTo(io.MultiWriter(tmp, h)); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = copyOwner(info, tmp); err != nil {
+		return err
+	}
+	if err = copySELinux(name, tmp); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = c.makeBackup(name); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	tmp = nil
+
+	if err = syncDir(dir); err != nil {
+		return err
+	}
+	c.stamp, err = newStamp(name, h.Sum(nil))
+	c.orig = lineSums(c.lines)
+	return err
+}
+
+// makeBackup makes a backup of the file, according to the backup mode.
+func (c *Config) makeBackup(name string) error {
+	var backup string
+
+	switch c.backup {
+	case NoBackup:
+		return nil
+	case SimpleBackup:
+		backup = name + ".bak"
+	case NumberedBackup:
+		backup = name + "." + strconv.Itoa(lastBackup(name)+1)
+	}
+
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// A hard link is enough since the original file is not modified.
+	if err := os.Link(name, backup); err == nil {
+		return nil
+	}
+	return copyFile(name, backup)
+}
+
+// lastBackup returns the number of the last numbered backup of the file, or
+// zero if there is none.
+func lastBackup(name string) int {
+	files, _ := filepath.Glob(name + ".*")
+	last := 0
+
+	for _, f := range files {
+		n, err := strconv.Atoi(strings.TrimPrefix(f, name+"."))
+		if err == nil && n > last {
+			last = n
+		}
+	}
+	return last
+}
+
+// copyFile copies the file src to dst, with the same permissions.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
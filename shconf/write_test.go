@@ -0,0 +1,116 @@
+// Copyright 2012 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package shconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "default")
+	if err = ioutil.WriteFile(name, []byte("# comment\nA=1\nB=2\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err = os.Symlink(name, link); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetBackup(NumberedBackup)
+
+	for _, v := range []string{"10", "20"} {
+		if err = cfg.WriteValue("B", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Error("the symbolic link was replaced")
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode got %v, want %v", info.Mode().Perm(), os.FileMode(0640))
+	}
+
+	for file, want := range map[string]string{
+		name:        "# comment\nA=1\nB=20\n",
+		name + ".1": "# comment\nA=1\nB=2\n",
+		name + ".2": "# comment\nA=1\nB=10\n",
+	} {
+		got, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != want {
+			t.Errorf("%s got %q, want %q", file, got, want)
+		}
+	}
+
+	cfg.SetBackup(SimpleBackup)
+	if err = cfg.WriteValue("A", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(name + ".bak"); err != nil {
+		t.Error(err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 5 {
+		for _, f := range files {
+			t.Log(f.Name())
+		}
+		t.Errorf("got %d files, want %d", len(files), 5)
+	}
+}
+
+func TestSave(t *testing.T) {
+	name := tempFile(t, "A=1\nB=2")
+
+	cfg, err := ParseFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.AddKey("C", "a b", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.DeleteKey("A"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "B=2\nC=\"a b\"\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if cfg, err = ParseString("A=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Save(); err != ErrNoFile {
+		t.Errorf("Save without file got error %v", err)
+	}
+}